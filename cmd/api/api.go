@@ -14,6 +14,8 @@ import (
 	"github.com/Alter-Sitanshu/CodeEditor/internal/mail"
 
 	"github.com/Alter-Sitanshu/CodeEditor/internal/auth"
+	"github.com/Alter-Sitanshu/CodeEditor/internal/languages"
+	"github.com/Alter-Sitanshu/CodeEditor/internal/ratelimit"
 	"github.com/Alter-Sitanshu/CodeEditor/internal/sockets"
 	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
 	"github.com/go-chi/chi/v5"
@@ -26,8 +28,12 @@ type Application struct {
 	authenticator auth.Authenticator
 	hub           *sockets.Hub
 	vcm           *sockets.VoiceChatManager
-	executor      *sockets.Judge0Executor
+	languages     *languages.Registry // languages the executor can run
+	executions    *sockets.ExecutionQueue
+	judge0        *sockets.Judge0Executor // nil unless Judge0 runs the code
 	mailer        *mail.SMTPSender
+	userLimiter   *ratelimit.Limiter
+	roomLimiter   *ratelimit.Limiter
 }
 
 type Config struct {
@@ -36,6 +42,8 @@ type Config struct {
 	tokencfg TokenConfig
 	auth     BasicAuthConfig
 	mailcfg  mail.SMTPConfig
+	limits   LimitConfig
+	ice      sockets.ICEConfig
 }
 
 type DBConfig struct {
@@ -49,6 +57,16 @@ type TokenConfig struct {
 	expiry time.Duration
 }
 
+// LimitConfig limits executions, counting judged submissions once per
+// test case towards the daily quotas. Zero disables a limit.
+type LimitConfig struct {
+	userPerMinute int
+	roomPerMinute int
+	burst         int
+	userDaily     int
+	roomDaily     int
+}
+
 type BasicAuthConfig struct {
 	username string
 	pass     string
@@ -72,6 +90,12 @@ func (app *Application) mount() http.Handler {
 	router.Route("/v1", func(r chi.Router) {
 		r.With(app.BasicAuthMiddleware()).Get("/health", app.HealthCheckHandler)
 
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(app.BasicAuthMiddleware())
+			r.Get("/usage", app.GetUsageHandler)
+			r.Delete("/usage/{scope}/{id}", app.ResetUsageHandler)
+		})
+
 		// The sign in page will make a post request here to get the JWT
 		r.Route("/auth", func(r chi.Router) {
 			r.Post("/token", app.TokenHandler)
@@ -82,21 +106,63 @@ func (app *Application) mount() http.Handler {
 			r.Post("/activate", app.ActivateUserHandler)
 		})
 
+		r.Get("/languages", app.GetLanguagesHandler)
+
 		r.Route("/user", func(r chi.Router) {
 			r.Use(app.AuthMiddleware)
 			r.Get("/", app.GetUserHandler)
 		})
 
+		// Called by our own services rather than users
+		r.Route("/internal", func(r chi.Router) {
+			r.Put("/judge0/callback", app.Judge0CallbackHandler)
+		})
+
+		r.Route("/problems", func(r chi.Router) {
+			r.Use(app.AuthMiddleware)
+			r.Post("/", app.CreateProblemHandler)
+			r.Get("/{pid}", app.GetProblemHandler)
+		})
+
 		r.Route("/rooms", func(r chi.Router) {
 			r.Use(app.AuthMiddleware)
 			r.Get("/", app.GetUserRoomsHandler)
 			r.Post("/", app.CreateRoomHandler)
 			r.Route("/{id}", func(r chi.Router) {
 				r.Use(app.RoomMiddleware)
-				r.Get("/", app.GetRoomHandler)
+				// Anyone can ask to join
 				r.Post("/request/{roleid}", app.RequestRoomHandler)
-				r.Get("/editor", app.EditorRoomHandler)
-				r.Post("/execute", app.ExecuteCodeHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireRoomRole(store.RoleGuest))
+					r.Get("/", app.GetRoomHandler)
+					r.Get("/editor", app.EditorRoomHandler)
+					r.Post("/execute", app.ExecuteCodeHandler)
+					r.Get("/executions", app.ListExecutionsHandler)
+					r.Get("/executions/{job}", app.GetExecutionHandler)
+					r.Delete("/executions/{job}", app.CancelExecutionHandler)
+					r.Get("/history", app.GetHistoryHandler)
+					r.Get("/history/{rev}", app.GetRevisionHandler)
+					r.Get("/replay", app.StreamReplayHandler)
+					r.Get("/replay/export", app.ExportReplayHandler)
+					r.Get("/files", app.GetFilesHandler)
+					r.Get("/problem", app.GetRoomProblemHandler)
+					r.Get("/voice", app.GetVoiceChatHandler)
+					r.Get("/voice/ice-servers", app.GetICEServersHandler)
+					r.Post("/submit", app.SubmitHandler)
+				})
+
+				// Changing the code and reading voice attendance need an
+				// editing role
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireRoomRole(store.RoleModerator))
+					r.Post("/restore/{rev}", app.RestoreRevisionHandler)
+					r.Post("/files", app.CreateFileHandler)
+					r.Patch("/files/*", app.MoveFileHandler)
+					r.Delete("/files/*", app.DeleteFileHandler)
+					r.Put("/problem", app.SetRoomProblemHandler)
+					r.Get("/voice/sessions", app.ListVoiceSessionsHandler)
+				})
 			})
 			r.Put("/{token}", app.AcceptMemberHandler)
 		})
@@ -141,6 +207,10 @@ func (app *Application) run(mux http.Handler) error {
 		return err
 	}
 
+	// Flush the room documents and edits still held in memory
+	app.hub.SaveDocuments()
+	app.hub.FlushHistory()
+
 	log.Println("Server Shutdown successful")
 	return nil
 }
@@ -1,18 +1,27 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/Alter-Sitanshu/CodeEditor/internal/auth"
 	"github.com/Alter-Sitanshu/CodeEditor/internal/env"
+	"github.com/Alter-Sitanshu/CodeEditor/internal/languages"
 	"github.com/Alter-Sitanshu/CodeEditor/internal/mail"
+	"github.com/Alter-Sitanshu/CodeEditor/internal/ratelimit"
+	"github.com/Alter-Sitanshu/CodeEditor/internal/sandbox"
 	"github.com/Alter-Sitanshu/CodeEditor/internal/sockets"
 	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
 	"github.com/joho/godotenv"
 )
 
 func main() {
+	// Must come first: sandboxed runs re-execute this binary
+	sandbox.Init()
+
 	err := godotenv.Load(".env")
 	if err != nil {
 		log.Fatal("Error loading .env", err.Error())
@@ -41,6 +50,22 @@ func main() {
 			From:     env.GetString("COMP_ADDR", "example@gmail.com"),
 			Expiry:   time.Hour * 24 * 3,
 		},
+		limits: LimitConfig{
+			userPerMinute: env.GetInt("EXECUTE_USER_PER_MINUTE", 10),
+			roomPerMinute: env.GetInt("EXECUTE_ROOM_PER_MINUTE", 30),
+			burst:         env.GetInt("EXECUTE_BURST", 5),
+			userDaily:     env.GetInt("EXECUTE_USER_DAILY", 200),
+			roomDaily:     env.GetInt("EXECUTE_ROOM_DAILY", 1000),
+		},
+		ice: sockets.ICEConfig{
+			STUNURLs: splitList(env.GetString("ICE_STUN_URLS", sockets.DefaultSTUNURL)),
+			TURNURLs: splitList(env.GetString("ICE_TURN_URLS", "")),
+			Secret:   env.GetString("TURN_SECRET", ""),
+			TTL:      time.Duration(env.GetInt("TURN_TTL", 3600)) * time.Second,
+		},
+	}
+	if len(cfg.ice.TURNURLs) > 0 && cfg.ice.Secret == "" {
+		log.Fatal("ICE_TURN_URLS needs a TURN_SECRET")
 	}
 
 	authenticator := auth.NewAuthenticator(
@@ -60,9 +85,53 @@ func main() {
 
 	psql := store.NewPostgresStore(db)
 
-	RoomHub := sockets.NewHub()
-	VoiceManager := sockets.NewVoiceChatManager()
-	executor := sockets.NewJudge0Executor()
+	RoomHub := sockets.NewHub(&psql)
+	VoiceManager := sockets.NewVoiceChatManager(&psql)
+	registry := languages.Default()
+	if path := env.GetString("LANGUAGES_FILE", ""); path != "" {
+		registry, err = languages.Load(path)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+	var executor sockets.Executor
+	var judge0 *sockets.Judge0Executor
+	executorKind := env.GetString("EXECUTOR", "judge0")
+	switch executorKind {
+	case "local":
+		executor, err = sockets.NewLocalExecutor(sockets.LocalConfig{
+			CgroupRoot: env.GetString("SANDBOX_CGROUP", "/sys/fs/cgroup/codeeditor"),
+			Languages:  registry,
+		})
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		registry = registry.Filter(func(lang languages.Language) bool { return lang.Local() })
+	default:
+		executorKind = "judge0"
+		judge0, err = newJudge0Executor(registry)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		executor = judge0
+		registry = registry.Filter(func(lang languages.Language) bool { return lang.Judge0ID > 0 })
+	}
+	var cache *sockets.ResultCache
+	switch env.GetString("EXECUTION_CACHE", "memory") {
+	case "memory":
+		cache = sockets.NewResultCache(env.GetInt("EXECUTION_CACHE_SIZE", sockets.DefaultCacheSize), nil)
+	case "postgres":
+		cache = sockets.NewResultCache(env.GetInt("EXECUTION_CACHE_SIZE", sockets.DefaultCacheSize), &psql)
+	case "off":
+	default:
+		log.Fatal("EXECUTION_CACHE must be memory, postgres or off")
+	}
+	if cache != nil {
+		executor = sockets.NewCachingExecutor(executor, executorKind, registry, cache)
+		go cache.RunPruning(time.Hour)
+	}
+	executions := sockets.NewExecutionQueue(executor, RoomHub, &psql,
+		sockets.DefaultExecutionWorkers, sockets.DefaultExecutionQueue)
 	mailer := mail.NewSMTPSender(cfg.mailcfg)
 
 	app := &Application{
@@ -71,11 +140,21 @@ func main() {
 		authenticator: *authenticator,
 		hub:           RoomHub,
 		vcm:           VoiceManager,
-		executor:      executor,
+		languages:     registry,
+		executions:    executions,
+		judge0:        judge0,
 		mailer:        mailer,
+		userLimiter:   ratelimit.New(cfg.limits.userPerMinute, cfg.limits.burst),
+		roomLimiter:   ratelimit.New(cfg.limits.roomPerMinute, cfg.limits.burst),
 	}
 
 	go app.hub.Run()
+	go app.hub.RunSnapshots(sockets.SnapshotInterval)
+	go app.hub.RunHistory()
+	go app.hub.RunPresence(sockets.PresenceInterval)
+	go app.executions.Run()
+	go app.userLimiter.RunPruning(time.Minute)
+	go app.roomLimiter.RunPruning(time.Minute)
 	handlerMux := app.mount()
 	err = app.run(handlerMux)
 
@@ -83,3 +162,47 @@ func main() {
 		log.Println(err.Error())
 	}
 }
+
+// newJudge0Executor configures Judge0 from the environment. The public
+// RapidAPI instance is used unless JUDGE0_URL points elsewhere.
+// JUDGE0_LANGUAGES changes the Judge0 IDs of the registry's languages.
+func newJudge0Executor(registry *languages.Registry) (*sockets.Judge0Executor, error) {
+	cfg := sockets.Judge0Config{
+		BaseURL:        env.GetString("JUDGE0_URL", sockets.DefaultJudge0URL),
+		AuthScheme:     env.GetString("JUDGE0_AUTH", sockets.Judge0AuthRapidAPI),
+		AuthToken:      env.GetString("JUDGE0_KEY", ""),
+		CallbackURL:    env.GetString("JUDGE0_CALLBACK_URL", ""),
+		CallbackSecret: env.GetString("JUDGE0_CALLBACK_SECRET", ""),
+	}
+	switch cfg.AuthScheme {
+	case sockets.Judge0AuthRapidAPI, sockets.Judge0AuthToken, sockets.Judge0AuthNone:
+	default:
+		return nil, fmt.Errorf("unknown JUDGE0_AUTH %q", cfg.AuthScheme)
+	}
+	if ids := env.GetString("JUDGE0_LANGUAGES", ""); ids != "" {
+		parsed, err := sockets.ParseJudge0Languages(ids)
+		if err != nil {
+			return nil, err
+		}
+		if err := registry.SetJudge0IDs(parsed); err != nil {
+			return nil, err
+		}
+	}
+	cfg.Languages = registry.Judge0IDs()
+	if cfg.CallbackURL != "" && cfg.CallbackSecret == "" {
+		return nil, errors.New("JUDGE0_CALLBACK_URL needs a JUDGE0_CALLBACK_SECRET")
+	}
+
+	return sockets.NewJudge0Executor(cfg), nil
+}
+
+// splitList splits a comma separated setting, dropping empty items
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
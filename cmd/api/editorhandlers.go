@@ -17,6 +17,7 @@ var upgrader = websocket.Upgrader{
 func (app *Application) EditorRoomHandler(w http.ResponseWriter, r *http.Request) {
 	room := getRoomFromctx(r)
 	user := getUserFromctx(r)
+	role := getRoleFromctx(r)
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err.Error())
@@ -25,10 +26,13 @@ func (app *Application) EditorRoomHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	clientConnection := &sockets.Connection{
-		RoomID: room.Id,
-		UserID: user.Id,
-		Conn:   conn,
-		Send:   make(chan []byte),
+		RoomID:   room.Id,
+		UserID:   user.Id,
+		Role:     role,
+		Engine:   room.Engine,
+		Language: room.Language,
+		Conn:     conn,
+		Send:     make(chan []byte, sockets.SendBufferSize),
 	}
 
 	app.hub.Register <- clientConnection
@@ -37,19 +41,3 @@ func (app *Application) EditorRoomHandler(w http.ResponseWriter, r *http.Request
 	go app.hub.ReadMessagesWithVoice(clientConnection, app.vcm)
 	go app.hub.WriteMessages(clientConnection)
 }
-
-func (app *Application) ExecuteCodeHandler(w http.ResponseWriter, r *http.Request) {
-	var req sockets.ExecuteRequest
-	readJSON(w, r, &req)
-	if req.Code == "" {
-		log.Printf("blank code submitted")
-		jsonResponse(w, http.StatusBadRequest, "blank code not allowed")
-	}
-	response, err := app.executor.ExecuteCode(req)
-	if err != nil {
-		log.Printf("error while executing code")
-		jsonResponse(w, http.StatusInternalServerError, *response)
-	}
-
-	jsonResponse(w, http.StatusOK, *response)
-}
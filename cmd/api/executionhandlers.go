@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/sockets"
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// Judge0CallbackHandler receives the results Judge0 pushes to the
+// callback_url of submissions
+func (app *Application) Judge0CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if app.judge0 == nil || !app.judge0.Callbacks() {
+		jsonResponse(w, http.StatusNotFound, "callbacks are not enabled")
+		return
+	}
+
+	// Judge0 sends more fields than we use, so unknown ones are allowed
+	var result sockets.Judge0Response
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		jsonResponse(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+
+	err := app.judge0.HandleCallback(r.URL.Query().Get("secret"), result)
+	if err != nil {
+		switch err {
+		case sockets.ErrBadCallback:
+			jsonResponse(w, http.StatusUnauthorized, "unauthorized")
+		case sockets.ErrBadCallbackPayload:
+			jsonResponse(w, http.StatusBadRequest, "invalid payload")
+		default:
+			log.Println(err.Error())
+			jsonResponse(w, http.StatusInternalServerError, "error handling callback")
+		}
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, "result received")
+}
+
+func (app *Application) ExecuteCodeHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	user := getUserFromctx(r)
+	var req sockets.ExecuteRequest
+	if err := readJSON(w, r, &req); err != nil {
+		jsonResponse(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	if req.Code == "" {
+		log.Printf("blank code submitted")
+		jsonResponse(w, http.StatusBadRequest, "blank code not allowed")
+		return
+	}
+	lang, err := app.languages.Get(req.Language)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.TimeLimit = lang.TimeLimit()
+	req.MemoryLimit = lang.MemoryLimitKb
+	charge, ok := app.allowExecution(w, r, user.Id, room.Id, 1)
+	if !ok {
+		return
+	}
+
+	job, err := app.executions.Submit(room.Id, user.Id, req, charge)
+	if err != nil {
+		app.refundExecution(r, charge)
+		switch err {
+		case sockets.ErrQueueFull, sockets.ErrTooManyInteractive:
+			jsonResponse(w, http.StatusServiceUnavailable, err.Error())
+		case sockets.ErrInteractiveUnsupported:
+			jsonResponse(w, http.StatusBadRequest, err.Error())
+		default:
+			log.Println(err.Error())
+			jsonResponse(w, http.StatusInternalServerError, "error queueing execution")
+		}
+		return
+	}
+
+	jsonResponse(w, http.StatusAccepted, job)
+}
+
+func (app *Application) GetExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	job, err := app.executions.Get(room.Id, chi.URLParam(r, "job"))
+	if err != nil {
+		jsonResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, job)
+}
+
+// ListExecutionsHandler pages through the room's execution history, newest
+// first. ?user= only lists the runs of one member.
+func (app *Application) ListExecutionsHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	before, limit, err := readPage(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var userID int64
+	if param := r.URL.Query().Get("user"); param != "" {
+		userID, err = strconv.ParseInt(param, 10, 64)
+		if err != nil || userID <= 0 {
+			jsonResponse(w, http.StatusBadRequest, "user is not valid")
+			return
+		}
+	}
+
+	ctx := r.Context()
+	executions, err := app.database.ExecutionStore.ListByRoom(ctx, room.Id, userID, int64(before), limit)
+	if err != nil {
+		log.Println(err.Error())
+		jsonResponse(w, http.StatusInternalServerError, "error fetching executions")
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, executions)
+}
+
+// CancelExecutionHandler stops an execution. Members can cancel their own
+// runs; moderators can cancel anybody's.
+func (app *Application) CancelExecutionHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	user := getUserFromctx(r)
+	jobID := chi.URLParam(r, "job")
+
+	job, err := app.executions.Get(room.Id, jobID)
+	if err != nil {
+		jsonResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if job.UserID != user.Id && getRoleFromctx(r) < store.RoleModerator {
+		jsonResponse(w, http.StatusForbidden, "insufficient role")
+		return
+	}
+
+	job, err = app.executions.Cancel(room.Id, jobID)
+	if err != nil {
+		switch err {
+		case sockets.ErrJobNotFound:
+			jsonResponse(w, http.StatusNotFound, err.Error())
+		case sockets.ErrJobDone:
+			jsonResponse(w, http.StatusConflict, err.Error())
+		default:
+			log.Println(err.Error())
+			jsonResponse(w, http.StatusInternalServerError, "error cancelling execution")
+		}
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, job)
+}
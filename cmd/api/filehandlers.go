@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type FilePayload struct {
+	Path  string `json:"path"`
+	IsDir bool   `json:"is_dir"`
+}
+
+type MovePayload struct {
+	Path string `json:"path"`
+}
+
+// fileError writes the response for an error of the file store
+func fileError(w http.ResponseWriter, err error) {
+	switch err {
+	case store.ErrInvalidPath, store.ErrNotADirectory:
+		jsonResponse(w, http.StatusBadRequest, err.Error())
+	case store.ErrFileExists:
+		jsonResponse(w, http.StatusConflict, err.Error())
+	case store.ErrNotFound:
+		jsonResponse(w, http.StatusNotFound, "file not found")
+	default:
+		log.Println(err.Error())
+		jsonResponse(w, http.StatusInternalServerError, "error updating files")
+	}
+}
+
+func (app *Application) GetFilesHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	ctx := r.Context()
+
+	files, err := app.database.FileStore.List(ctx, room.Id)
+	if err != nil {
+		log.Println(err.Error())
+		jsonResponse(w, http.StatusInternalServerError, "error fetching files")
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, files)
+}
+
+func (app *Application) CreateFileHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	user := getUserFromctx(r)
+	var payload FilePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		jsonResponse(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+
+	file := store.RoomFile{
+		RoomId: room.Id,
+		Path:   payload.Path,
+		IsDir:  payload.IsDir,
+	}
+	ctx := r.Context()
+	if err := app.database.FileStore.Create(ctx, &file); err != nil {
+		fileError(w, err)
+		return
+	}
+	app.hub.FileCreated(user.Id, room.Engine, file)
+
+	jsonResponse(w, http.StatusCreated, file)
+}
+
+func (app *Application) MoveFileHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	user := getUserFromctx(r)
+	from := chi.URLParam(r, "*")
+	var payload MovePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		jsonResponse(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+
+	ctx := r.Context()
+	if err := app.database.FileStore.Move(ctx, room.Id, from, payload.Path); err != nil {
+		fileError(w, err)
+		return
+	}
+	app.hub.FileMoved(room.Id, user.Id, from, payload.Path)
+
+	jsonResponse(w, http.StatusOK, "file moved")
+}
+
+func (app *Application) DeleteFileHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	user := getUserFromctx(r)
+	path := chi.URLParam(r, "*")
+
+	ctx := r.Context()
+	if err := app.database.FileStore.Delete(ctx, room.Id, path); err != nil {
+		fileError(w, err)
+		return
+	}
+	app.hub.FileDeleted(room.Id, user.Id, path)
+
+	jsonResponse(w, http.StatusOK, "file deleted")
+}
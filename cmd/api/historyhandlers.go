@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/sockets"
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+var errBadPage = errors.New("invalid pagination parameters")
+
+type RevisionContent struct {
+	File     string `json:"file"`
+	Revision int    `json:"revision"`
+	Content  string `json:"content"`
+}
+
+// readFile returns the ?file= the request is about, the default file if
+// it is missing
+func readFile(r *http.Request) string {
+	if file := r.URL.Query().Get("file"); file != "" {
+		return file
+	}
+	return store.DefaultFile
+}
+
+// readPage parses the ?before=&limit= pagination query
+func readPage(r *http.Request) (before int, limit int, err error) {
+	limit = defaultPageSize
+	if param := r.URL.Query().Get("limit"); param != "" {
+		limit, err = strconv.Atoi(param)
+		if err != nil || limit <= 0 {
+			return 0, 0, errBadPage
+		}
+		limit = min(limit, maxPageSize)
+	}
+	if param := r.URL.Query().Get("before"); param != "" {
+		before, err = strconv.Atoi(param)
+		if err != nil || before < 0 {
+			return 0, 0, errBadPage
+		}
+	}
+	return before, limit, nil
+}
+
+func (app *Application) GetHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	before, limit, err := readPage(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	revisions, err := app.database.DocumentStore.GetRevisions(ctx, room.Id, readFile(r), before, limit)
+	if err != nil {
+		log.Println(err.Error())
+		jsonResponse(w, http.StatusInternalServerError, "error fetching history")
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, revisions)
+}
+
+// contentAt rebuilds a file of the room as it was at the {rev} URL parameter
+func (app *Application) contentAt(w http.ResponseWriter, r *http.Request) (*RevisionContent, bool) {
+	room := getRoomFromctx(r)
+	file := readFile(r)
+	rev, err := strconv.Atoi(chi.URLParam(r, "rev"))
+	if err != nil || rev <= 0 {
+		jsonResponse(w, http.StatusBadRequest, "revision is not valid")
+		return nil, false
+	}
+
+	ctx := r.Context()
+	revisions, err := app.database.DocumentStore.GetRevisionsUpTo(ctx, room.Id, file, rev)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			jsonResponse(w, http.StatusNotFound, "revision not found")
+		default:
+			log.Println(err.Error())
+			jsonResponse(w, http.StatusInternalServerError, "error fetching history")
+		}
+		return nil, false
+	}
+	content, err := sockets.RebuildContent(revisions)
+	if err != nil {
+		log.Println(err.Error())
+		jsonResponse(w, http.StatusInternalServerError, "error rebuilding revision")
+		return nil, false
+	}
+
+	return &RevisionContent{File: file, Revision: rev, Content: content}, true
+}
+
+func (app *Application) GetRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	revision, ok := app.contentAt(w, r)
+	if !ok {
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, revision)
+}
+
+func (app *Application) RestoreRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	user := getUserFromctx(r)
+	revision, ok := app.contentAt(w, r)
+	if !ok {
+		return
+	}
+
+	newRev, err := app.hub.ReplaceContent(room.Id, room.Engine, revision.File, user.Id, revision.Content)
+	if err != nil {
+		switch err {
+		case sockets.ErrFileNotFound:
+			jsonResponse(w, http.StatusNotFound, "file not found")
+		default:
+			log.Println(err.Error())
+			jsonResponse(w, http.StatusInternalServerError, "error restoring revision")
+		}
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, RevisionContent{
+		File:     revision.File,
+		Revision: newRev,
+		Content:  revision.Content,
+	})
+}
@@ -0,0 +1,8 @@
+package main
+
+import "net/http"
+
+// GetLanguagesHandler lists the languages code can be run in
+func (app *Application) GetLanguagesHandler(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, http.StatusOK, app.languages.List())
+}
@@ -8,15 +8,18 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
 	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type Userctx string
 type Roomctx string
+type Rolectx string
 
 const userctx Userctx = "user"
 const roomctx Roomctx = "room"
+const rolectx Rolectx = "role"
 
 func (app *Application) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -101,16 +104,54 @@ func (app *Application) RoomMiddleware(next http.Handler) http.Handler {
 		ctx := r.Context()
 		if err != nil {
 			log.Println(err.Error())
-			jsonResponse(w, http.StatusBadRequest, err.Error())
+			jsonResponse(w, http.StatusBadRequest, "room id is not valid")
+			return
 		}
 
 		room, err := app.database.RoomStore.GetRoomById(ctx, roomId)
 		if err != nil {
-			log.Println(err.Error())
-			jsonResponse(w, http.StatusInternalServerError, err.Error())
+			switch err {
+			case store.ErrNotFound:
+				jsonResponse(w, http.StatusNotFound, "room not found")
+			default:
+				log.Println(err.Error())
+				jsonResponse(w, http.StatusInternalServerError, "error fetching room")
+			}
+			return
 		}
 
 		ctx = context.WithValue(ctx, roomctx, room)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// RequireRoomRole only lets members of the room loaded by RoomMiddleware
+// with at least minRole through, and puts their role in the context
+func (app *Application) RequireRoomRole(minRole int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := getUserFromctx(r)
+			room := getRoomFromctx(r)
+			ctx := r.Context()
+
+			role, err := app.database.RoomStore.GetMemberRole(ctx, room.Id, user.Id)
+			if err != nil {
+				switch err {
+				case store.ErrNotFound:
+					jsonResponse(w, http.StatusForbidden, "not a member of the room")
+				default:
+					log.Println(err.Error())
+					jsonResponse(w, http.StatusInternalServerError, "error fetching role")
+				}
+				return
+			}
+			if role < minRole {
+				jsonResponse(w, http.StatusForbidden, "insufficient role")
+				return
+			}
+
+			ctx = context.WithValue(ctx, rolectx, role)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
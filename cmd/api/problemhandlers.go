@@ -0,0 +1,257 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/sockets"
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// Limits of a test case that does not set its own, and the most it may set
+const (
+	defaultCaseTimeLimitMs   = 2000
+	defaultCaseMemoryLimitKb = 256 << 10
+	maxCaseTimeLimitMs       = 10000
+	maxCaseMemoryLimitKb     = 512 << 10
+	maxProblemCases          = 100
+)
+
+type ProblemPayload struct {
+	Title     string              `json:"title"`
+	Statement string              `json:"statement"`
+	Cases     []store.ProblemCase `json:"cases"`
+}
+
+type RoomProblemPayload struct {
+	ProblemId *int64 `json:"problem_id"`
+}
+
+type SubmitPayload struct {
+	Language string `json:"language"`
+	// Code to judge, the content of File in the room when empty
+	Code string `json:"code"`
+	File string `json:"file"`
+	// Run every case even if its result is cached
+	NoCache bool `json:"no_cache"`
+}
+
+// publicProblem hides the hidden cases of a problem from everyone but
+// its author
+func publicProblem(problem *store.Problem, userID int64) *store.Problem {
+	if problem.AuthorId == userID {
+		return problem
+	}
+	public := *problem
+	public.Cases = problem.Samples()
+	return &public
+}
+
+func (app *Application) CreateProblemHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromctx(r)
+	var payload ProblemPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		jsonResponse(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	if payload.Title == "" {
+		jsonResponse(w, http.StatusBadRequest, "title is required")
+		return
+	}
+	if len(payload.Cases) == 0 || len(payload.Cases) > maxProblemCases {
+		jsonResponse(w, http.StatusBadRequest, "a problem needs between 1 and 100 test cases")
+		return
+	}
+	for i := range payload.Cases {
+		c := &payload.Cases[i]
+		if c.TimeLimitMs == 0 {
+			c.TimeLimitMs = defaultCaseTimeLimitMs
+		}
+		if c.MemoryLimitKb == 0 {
+			c.MemoryLimitKb = defaultCaseMemoryLimitKb
+		}
+		if c.TimeLimitMs < 0 || c.TimeLimitMs > maxCaseTimeLimitMs ||
+			c.MemoryLimitKb < 0 || c.MemoryLimitKb > maxCaseMemoryLimitKb {
+			jsonResponse(w, http.StatusBadRequest, "test case limits are not valid")
+			return
+		}
+	}
+
+	problem := &store.Problem{
+		Title:     payload.Title,
+		Statement: payload.Statement,
+		AuthorId:  user.Id,
+		Cases:     payload.Cases,
+	}
+	ctx := r.Context()
+	if err := app.database.ProblemStore.Create(ctx, problem); err != nil {
+		log.Println(err.Error())
+		jsonResponse(w, http.StatusInternalServerError, "error creating problem")
+		return
+	}
+
+	jsonResponse(w, http.StatusCreated, problem)
+}
+
+func (app *Application) GetProblemHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromctx(r)
+	problemID, err := strconv.ParseInt(chi.URLParam(r, "pid"), 10, 64)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, "problem id is not valid")
+		return
+	}
+
+	ctx := r.Context()
+	problem, err := app.database.ProblemStore.GetById(ctx, problemID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			jsonResponse(w, http.StatusNotFound, "problem not found")
+		default:
+			log.Println(err.Error())
+			jsonResponse(w, http.StatusInternalServerError, "error fetching problem")
+		}
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, publicProblem(problem, user.Id))
+}
+
+// roomProblem fetches the problem of the room in the context, writing the
+// error response if there is none
+func (app *Application) roomProblem(w http.ResponseWriter, r *http.Request) (*store.Problem, bool) {
+	room := getRoomFromctx(r)
+	if room.ProblemId == nil {
+		jsonResponse(w, http.StatusNotFound, "room has no problem")
+		return nil, false
+	}
+
+	ctx := r.Context()
+	problem, err := app.database.ProblemStore.GetById(ctx, *room.ProblemId)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			jsonResponse(w, http.StatusNotFound, "room has no problem")
+		default:
+			log.Println(err.Error())
+			jsonResponse(w, http.StatusInternalServerError, "error fetching problem")
+		}
+		return nil, false
+	}
+	return problem, true
+}
+
+func (app *Application) GetRoomProblemHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromctx(r)
+	problem, ok := app.roomProblem(w, r)
+	if !ok {
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, publicProblem(problem, user.Id))
+}
+
+// SetRoomProblemHandler picks the problem the room is judged against, or
+// clears it with a null problem_id
+func (app *Application) SetRoomProblemHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	var payload RoomProblemPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		jsonResponse(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+
+	ctx := r.Context()
+	if payload.ProblemId != nil {
+		_, err := app.database.ProblemStore.GetById(ctx, *payload.ProblemId)
+		if err != nil {
+			switch err {
+			case store.ErrNotFound:
+				jsonResponse(w, http.StatusNotFound, "problem not found")
+			default:
+				log.Println(err.Error())
+				jsonResponse(w, http.StatusInternalServerError, "error fetching problem")
+			}
+			return
+		}
+	}
+	if err := app.database.RoomStore.SetProblem(ctx, room.Id, payload.ProblemId); err != nil {
+		log.Println(err.Error())
+		jsonResponse(w, http.StatusInternalServerError, "error updating room")
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, payload)
+}
+
+// SubmitHandler queues the room's code to be judged against every case of
+// its problem and answers with the queued job, not the verdicts. The
+// verdicts arrive as "submission-case" messages and the submission with
+// "execution-finished". GET /executions/{job} holds the submission with
+// every case's verdict once the job is finished.
+func (app *Application) SubmitHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	user := getUserFromctx(r)
+	var payload SubmitPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		jsonResponse(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	if payload.Language == "" {
+		payload.Language = room.Language
+	}
+	if payload.File == "" {
+		payload.File = store.DefaultFile
+	}
+	if _, err := app.languages.Get(payload.Language); err != nil {
+		jsonResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	problem, ok := app.roomProblem(w, r)
+	if !ok {
+		return
+	}
+
+	code := payload.Code
+	if code == "" {
+		content, err := app.hub.FileContent(room.Id, room.Engine, payload.File)
+		if err != nil {
+			switch err {
+			case sockets.ErrFileNotFound:
+				jsonResponse(w, http.StatusNotFound, "file not found")
+			default:
+				log.Println(err.Error())
+				jsonResponse(w, http.StatusInternalServerError, "error reading code")
+			}
+			return
+		}
+		code = content
+	}
+	if code == "" {
+		jsonResponse(w, http.StatusBadRequest, "blank code not allowed")
+		return
+	}
+	charge, ok := app.allowExecution(w, r, user.Id, room.Id, len(problem.Cases))
+	if !ok {
+		return
+	}
+
+	req := sockets.ExecuteRequest{Code: code, Language: payload.Language, NoCache: payload.NoCache}
+	job, err := app.executions.SubmitJudge(room.Id, user.Id, req, problem, charge)
+	if err != nil {
+		app.refundExecution(r, charge)
+		switch err {
+		case sockets.ErrQueueFull:
+			jsonResponse(w, http.StatusServiceUnavailable, err.Error())
+		default:
+			log.Println(err.Error())
+			jsonResponse(w, http.StatusInternalServerError, "error queueing submission")
+		}
+		return
+	}
+
+	jsonResponse(w, http.StatusAccepted, job)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/sockets"
+	"github.com/gorilla/websocket"
+)
+
+// roomReplay loads the room's recording as replay events
+func (app *Application) roomReplay(w http.ResponseWriter, r *http.Request) ([]sockets.ReplayEvent, bool) {
+	room := getRoomFromctx(r)
+	ctx := r.Context()
+
+	revisions, err := app.database.DocumentStore.GetRevisionLog(ctx, room.Id)
+	if err != nil {
+		log.Println(err.Error())
+		jsonResponse(w, http.StatusInternalServerError, "error fetching recording")
+		return nil, false
+	}
+	events, err := sockets.BuildReplay(revisions)
+	if err != nil {
+		log.Println(err.Error())
+		jsonResponse(w, http.StatusInternalServerError, "error rebuilding recording")
+		return nil, false
+	}
+
+	return events, true
+}
+
+func (app *Application) ExportReplayHandler(w http.ResponseWriter, r *http.Request) {
+	events, ok := app.roomReplay(w, r)
+	if !ok {
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, events)
+}
+
+func (app *Application) StreamReplayHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	speed := 1.0
+	if param := r.URL.Query().Get("speed"); param != "" {
+		var err error
+		speed, err = strconv.ParseFloat(param, 64)
+		if err != nil || !sockets.ReplaySpeeds[speed] {
+			jsonResponse(w, http.StatusBadRequest, "speed must be 1, 2 or 10")
+			return
+		}
+	}
+	// Idle stretches play out in full unless ?max_gap= shortens them
+	var maxGap time.Duration
+	if param := r.URL.Query().Get("max_gap"); param != "" {
+		seconds, err := strconv.Atoi(param)
+		if err != nil || seconds <= 0 {
+			jsonResponse(w, http.StatusBadRequest, "max_gap must be a positive number of seconds")
+			return
+		}
+		maxGap = time.Duration(seconds) * time.Second
+	}
+
+	events, ok := app.roomReplay(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	defer conn.Close()
+
+	// The viewer only listens; a failed read means it went away
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := sockets.StreamReplay(conn, room.Id, events, speed, maxGap, done); err != nil {
+		log.Printf("Replay of room %d stopped: %v", room.Id, err)
+		return
+	}
+	conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
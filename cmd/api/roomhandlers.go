@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/Alter-Sitanshu/CodeEditor/internal/sockets"
 	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -16,6 +17,7 @@ import (
 type RoomPayload struct {
 	Name     string `json:"name"`
 	Language string `json:"language"`
+	Engine   string `json:"engine"`
 }
 
 func getRoomFromctx(r *http.Request) *store.Room {
@@ -23,6 +25,12 @@ func getRoomFromctx(r *http.Request) *store.Room {
 	return room
 }
 
+// getRoleFromctx returns the caller's role set by RequireRoomRole
+func getRoleFromctx(r *http.Request) int {
+	role := r.Context().Value(rolectx).(int)
+	return role
+}
+
 func (app *Application) GetUserRoomsHandler(w http.ResponseWriter, r *http.Request) {
 	user := getUserFromctx(r)
 	ctx := r.Context()
@@ -46,11 +54,23 @@ func (app *Application) CreateRoomHandler(w http.ResponseWriter, r *http.Request
 	user := getUserFromctx(r)
 	var payload RoomPayload
 	readJSON(w, r, &payload)
+	if payload.Engine == "" {
+		payload.Engine = sockets.EngineOT
+	}
+	if !sockets.IsEngine(payload.Engine) {
+		jsonResponse(w, http.StatusBadRequest, "unsupported sync engine")
+		return
+	}
+	if _, err := app.languages.Get(payload.Language); err != nil {
+		jsonResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	room := store.Room{
 		Name:     payload.Name,
 		Author:   user,
 		Language: payload.Language,
+		Engine:   payload.Engine,
 	}
 	ctx := r.Context()
 	err := app.database.RoomStore.Create(ctx, &room)
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// tooManyRequests writes a 429 telling the client when to retry
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	jsonResponse(w, http.StatusTooManyRequests, message)
+}
+
+// allowExecution checks the rate limits of the user and room and counts n
+// executions towards their daily quotas, writing the response if they
+// are not allowed. The charge is given back with refundExecution if the
+// executions do not run.
+func (app *Application) allowExecution(w http.ResponseWriter, r *http.Request,
+	userID, roomID int64, n int) (store.UsageCharge, bool) {
+	userKey := fmt.Sprintf("user:%d", userID)
+	if ok, wait := app.userLimiter.Allow(userKey); !ok {
+		tooManyRequests(w, wait, "too many executions, slow down")
+		return store.UsageCharge{}, false
+	}
+	if ok, wait := app.roomLimiter.Allow(fmt.Sprintf("room:%d", roomID)); !ok {
+		// The user's token is not spent on a refused execution
+		app.userLimiter.Refund(userKey)
+		tooManyRequests(w, wait, "too many executions in the room, slow down")
+		return store.UsageCharge{}, false
+	}
+
+	limits := app.config.limits
+	if limits.userDaily <= 0 && limits.roomDaily <= 0 {
+		return store.UsageCharge{}, true
+	}
+	ctx := r.Context()
+	charge, err := app.database.UsageStore.Consume(ctx, userID, roomID, n, limits.userDaily, limits.roomDaily)
+	if err != nil {
+		switch err {
+		case store.ErrUserQuotaExceeded, store.ErrRoomQuotaExceeded:
+			tomorrow := store.UsageDay(time.Now()).Add(24 * time.Hour)
+			tooManyRequests(w, time.Until(tomorrow), err.Error())
+		default:
+			log.Println(err.Error())
+			jsonResponse(w, http.StatusInternalServerError, "error checking quota")
+		}
+		return store.UsageCharge{}, false
+	}
+	return charge, true
+}
+
+// refundExecution gives back a charge of executions that were not queued
+func (app *Application) refundExecution(r *http.Request, charge store.UsageCharge) {
+	if err := app.database.UsageStore.Refund(r.Context(), charge); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// readDay parses the ?day=YYYY-MM-DD query, today if it is missing
+func readDay(r *http.Request) (time.Time, error) {
+	param := r.URL.Query().Get("day")
+	if param == "" {
+		return store.UsageDay(time.Now()), nil
+	}
+	return time.Parse(time.DateOnly, param)
+}
+
+// GetUsageHandler lists the executions of users and rooms on a day
+func (app *Application) GetUsageHandler(w http.ResponseWriter, r *http.Request) {
+	day, err := readDay(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, "day is not valid")
+		return
+	}
+	scope := r.URL.Query().Get("scope")
+	if scope != "" && scope != store.UsageUser && scope != store.UsageRoom {
+		jsonResponse(w, http.StatusBadRequest, "scope is not valid")
+		return
+	}
+
+	ctx := r.Context()
+	usage, err := app.database.UsageStore.List(ctx, day, scope)
+	if err != nil {
+		log.Println(err.Error())
+		jsonResponse(w, http.StatusInternalServerError, "error fetching usage")
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, usage)
+}
+
+// ResetUsageHandler clears the usage of a user or room on a day and
+// lifts its rate limit
+func (app *Application) ResetUsageHandler(w http.ResponseWriter, r *http.Request) {
+	scope := chi.URLParam(r, "scope")
+	if scope != store.UsageUser && scope != store.UsageRoom {
+		jsonResponse(w, http.StatusBadRequest, "scope is not valid")
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, "id is not valid")
+		return
+	}
+	day, err := readDay(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, "day is not valid")
+		return
+	}
+
+	ctx := r.Context()
+	if err := app.database.UsageStore.Reset(ctx, scope, id, day); err != nil {
+		log.Println(err.Error())
+		jsonResponse(w, http.StatusInternalServerError, "error resetting usage")
+		return
+	}
+	key := fmt.Sprintf("%s:%d", scope, id)
+	if scope == store.UsageUser {
+		app.userLimiter.Reset(key)
+	} else {
+		app.roomLimiter.Reset(key)
+	}
+
+	jsonResponse(w, http.StatusOK, "usage reset")
+}
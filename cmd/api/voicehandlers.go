@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// GetVoiceChatHandler returns who is in the room's voice chat right now
+func (app *Application) GetVoiceChatHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	jsonResponse(w, http.StatusOK, app.vcm.Snapshot(room.Id))
+}
+
+// ListVoiceSessionsHandler pages through the room's ended voice chats,
+// newest first, with how long each member stayed
+func (app *Application) ListVoiceSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	before, limit, err := readPage(r)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	sessions, err := app.database.VoiceSessionStore.ListByRoom(ctx, room.Id, int64(before), limit)
+	if err != nil {
+		log.Println(err.Error())
+		jsonResponse(w, http.StatusInternalServerError, "error fetching voice sessions")
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, sessions)
+}
+
+// GetICEServersHandler gives a member of the room the STUN/TURN servers
+// for its voice chat, with TURN credentials of their own
+func (app *Application) GetICEServersHandler(w http.ResponseWriter, r *http.Request) {
+	room := getRoomFromctx(r)
+	user := getUserFromctx(r)
+
+	servers := app.config.ice.ICEServers(room.Id, user.Id, time.Now())
+	// The credentials are personal and expire
+	w.Header().Set("Cache-Control", "no-store")
+	jsonResponse(w, http.StatusOK, servers)
+}
@@ -0,0 +1,3 @@
+ALTER TABLE rooms
+ADD COLUMN IF NOT EXISTS sync_engine VARCHAR(10) NOT NULL DEFAULT 'ot'
+CHECK(sync_engine IN ('ot', 'crdt'));
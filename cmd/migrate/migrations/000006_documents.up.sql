@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS documents(
+    room_id BIGINT PRIMARY KEY REFERENCES rooms(id) ON DELETE CASCADE,
+    content TEXT NOT NULL DEFAULT '',
+    revision INT NOT NULL DEFAULT 0,
+    sync_engine VARCHAR(10) NOT NULL DEFAULT 'ot',
+    state JSONB,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS document_revisions(
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    revision INT NOT NULL,
+    user_id BIGINT NOT NULL,
+    position INT NOT NULL,
+    delete_count INT NOT NULL,
+    insert_text TEXT NOT NULL,
+    snapshot TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY(room_id, revision)
+);
@@ -0,0 +1,13 @@
+DELETE FROM document_revisions WHERE path <> 'main';
+ALTER TABLE document_revisions DROP CONSTRAINT IF EXISTS document_revisions_room_id_path_fkey;
+ALTER TABLE document_revisions DROP CONSTRAINT IF EXISTS document_revisions_pkey;
+ALTER TABLE document_revisions DROP COLUMN IF EXISTS path;
+ALTER TABLE document_revisions ADD PRIMARY KEY (room_id, revision);
+
+DELETE FROM documents WHERE path <> 'main';
+ALTER TABLE documents DROP CONSTRAINT IF EXISTS documents_room_id_path_fkey;
+ALTER TABLE documents DROP CONSTRAINT IF EXISTS documents_pkey;
+ALTER TABLE documents DROP COLUMN IF EXISTS path;
+ALTER TABLE documents ADD PRIMARY KEY (room_id);
+
+DROP TABLE IF EXISTS room_files;
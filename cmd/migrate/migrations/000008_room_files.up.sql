@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS room_files(
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    path VARCHAR(255) NOT NULL,
+    is_dir BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY(room_id, path)
+);
+
+-- Every existing room gets its single buffer as the file "main"
+INSERT INTO room_files (room_id, path)
+SELECT id, 'main' FROM rooms
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS path VARCHAR(255) NOT NULL DEFAULT 'main';
+ALTER TABLE documents DROP CONSTRAINT IF EXISTS documents_pkey;
+ALTER TABLE documents ADD PRIMARY KEY (room_id, path);
+ALTER TABLE documents ADD FOREIGN KEY (room_id, path)
+    REFERENCES room_files(room_id, path) ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE document_revisions ADD COLUMN IF NOT EXISTS path VARCHAR(255) NOT NULL DEFAULT 'main';
+ALTER TABLE document_revisions DROP CONSTRAINT IF EXISTS document_revisions_pkey;
+ALTER TABLE document_revisions ADD PRIMARY KEY (room_id, path, revision);
+ALTER TABLE document_revisions ADD FOREIGN KEY (room_id, path)
+    REFERENCES room_files(room_id, path) ON DELETE CASCADE ON UPDATE CASCADE;
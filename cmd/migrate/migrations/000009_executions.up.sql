@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS executions(
+    id BIGSERIAL PRIMARY KEY,
+    job_id UUID NOT NULL UNIQUE,
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    language VARCHAR(32) NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    stdin TEXT NOT NULL DEFAULT '',
+    stdout TEXT NOT NULL DEFAULT '',
+    stderr TEXT NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL,
+    exit_code INT,
+    runtime_ms INT,
+    memory_kb INT,
+    created_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS executions_room_idx ON executions(room_id, id);
+CREATE INDEX IF NOT EXISTS executions_user_idx ON executions(user_id, id);
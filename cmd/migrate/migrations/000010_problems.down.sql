@@ -0,0 +1,3 @@
+ALTER TABLE rooms DROP COLUMN IF EXISTS problem_id;
+DROP TABLE IF EXISTS problem_cases;
+DROP TABLE IF EXISTS problems;
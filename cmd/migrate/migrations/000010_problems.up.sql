@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS problems(
+    id BIGSERIAL PRIMARY KEY,
+    title VARCHAR(255) NOT NULL,
+    statement TEXT NOT NULL DEFAULT '',
+    author BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS problem_cases(
+    problem_id BIGINT NOT NULL REFERENCES problems(id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    input TEXT NOT NULL DEFAULT '',
+    expected_output TEXT NOT NULL DEFAULT '',
+    hidden BOOLEAN NOT NULL DEFAULT FALSE,
+    time_limit_ms INT NOT NULL,
+    memory_limit_kb INT NOT NULL,
+
+    PRIMARY KEY(problem_id, position)
+);
+
+ALTER TABLE rooms
+ADD COLUMN IF NOT EXISTS problem_id BIGINT REFERENCES problems(id) ON DELETE SET NULL;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS execution_usage(
+    scope VARCHAR(8) NOT NULL CHECK(scope IN ('user', 'room')),
+    subject_id BIGINT NOT NULL,
+    day DATE NOT NULL,
+    count INT NOT NULL DEFAULT 0,
+
+    PRIMARY KEY(scope, subject_id, day)
+);
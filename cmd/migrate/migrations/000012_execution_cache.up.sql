@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS execution_cache(
+    key CHAR(64) PRIMARY KEY,
+    response JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS execution_cache_created_idx ON execution_cache(created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS voice_session_attendance;
+DROP TABLE IF EXISTS voice_sessions;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS voice_sessions(
+    id BIGSERIAL PRIMARY KEY,
+    room_id BIGINT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    started_at TIMESTAMPTZ NOT NULL,
+    ended_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS voice_session_attendance(
+    id BIGSERIAL PRIMARY KEY,
+    session_id BIGINT NOT NULL REFERENCES voice_sessions(id) ON DELETE CASCADE,
+    user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    joined_at TIMESTAMPTZ NOT NULL,
+    left_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS voice_sessions_room_idx ON voice_sessions(room_id, id);
+CREATE INDEX IF NOT EXISTS voice_session_attendance_session_idx ON voice_session_attendance(session_id);
@@ -0,0 +1,3 @@
+UPDATE executions SET status = result_status WHERE result_status IS NOT NULL;
+
+ALTER TABLE executions DROP COLUMN IF EXISTS result_status;
@@ -0,0 +1,6 @@
+ALTER TABLE executions
+ADD COLUMN IF NOT EXISTS result_status VARCHAR(32);
+
+-- Finished jobs used to store the result status in status
+UPDATE executions SET result_status = status, status = 'finished'
+WHERE status NOT IN ('finished', 'failed', 'cancelled');
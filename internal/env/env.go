@@ -2,6 +2,7 @@ package env
 
 import (
 	"os"
+	"strconv"
 )
 
 func GetString(key, fallback string) string {
@@ -11,3 +12,17 @@ func GetString(key, fallback string) string {
 	}
 	return val
 }
+
+// GetInt returns the integer value of key, or fallback if it is unset or
+// not a number
+func GetInt(key string, fallback int) int {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return fallback
+	}
+	return n
+}
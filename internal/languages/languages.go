@@ -0,0 +1,224 @@
+// Package languages is the catalog of languages code can be run in and
+// how each of them is built and run by the executors.
+package languages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Most a language may set as its limits
+const (
+	MaxTimeLimitMs   = 60_000
+	MaxMemoryLimitKb = 2 << 20
+)
+
+var ErrUnknownLanguage = errors.New("language is not supported")
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9+#._-]*$`)
+
+// Language describes a language and how it runs. Judge0ID is used by the
+// Judge0 executor; File, Compile and Run by the local one, which runs
+// them in the sandbox's work directory.
+type Language struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Version     string `json:"version"`
+	Extension   string `json:"extension"`
+	Judge0ID    int    `json:"judge0_id,omitempty"`
+	// Source file the code is written to
+	File    string   `json:"file,omitempty"`
+	Compile []string `json:"compile,omitempty"`
+	Run     []string `json:"run,omitempty"`
+	// Host paths the toolchain needs besides the system directories and
+	// its own, which the local executor finds on its PATH
+	Mounts []string `json:"mounts,omitempty"`
+	// Limits of a run, zero for the executor's own
+	TimeLimitMs   int `json:"time_limit_ms,omitempty"`
+	MemoryLimitKb int `json:"memory_limit_kb,omitempty"`
+	// Results of runs may be cached. Only set it for languages whose
+	// programs cannot read the time, randomness or the network.
+	Cacheable bool `json:"cacheable,omitempty"`
+}
+
+// TimeLimit returns the run time limit of the language, zero if unset
+func (l *Language) TimeLimit() time.Duration {
+	return time.Duration(l.TimeLimitMs) * time.Millisecond
+}
+
+// Local reports whether the local executor can run the language
+func (l *Language) Local() bool {
+	return len(l.Run) > 0
+}
+
+func (l *Language) validate() error {
+	if !validName.MatchString(l.Name) {
+		return fmt.Errorf("language name %q is not valid", l.Name)
+	}
+	if l.Judge0ID < 0 {
+		return fmt.Errorf("judge0_id of %s is not valid", l.Name)
+	}
+	if l.Judge0ID == 0 && !l.Local() {
+		return fmt.Errorf("%s needs a judge0_id or run command", l.Name)
+	}
+	if l.Local() && !filepath.IsLocal(l.File) {
+		return fmt.Errorf("file of %s is not valid", l.Name)
+	}
+	for _, mount := range l.Mounts {
+		if !filepath.IsAbs(mount) || filepath.Clean(mount) == "/" {
+			return fmt.Errorf("mount %q of %s is not valid", mount, l.Name)
+		}
+	}
+	if l.TimeLimitMs < 0 || l.TimeLimitMs > MaxTimeLimitMs {
+		return fmt.Errorf("time_limit_ms of %s is not valid", l.Name)
+	}
+	if l.MemoryLimitKb < 0 || l.MemoryLimitKb > MaxMemoryLimitKb {
+		return fmt.Errorf("memory_limit_kb of %s is not valid", l.Name)
+	}
+	return nil
+}
+
+// Judge0 IDs are those of the public instance
+var defaults = []Language{
+	{
+		Name:        "go",
+		DisplayName: "Go",
+		Version:     "1.18.5",
+		Extension:   ".go",
+		Judge0ID:    95,
+		File:        "main.go",
+		Compile:     []string{"go", "build", "-o", "main", "main.go"},
+		Run:         []string{"./main"},
+	},
+	{
+		Name:        "python",
+		DisplayName: "Python",
+		Version:     "3.10.0",
+		Extension:   ".py",
+		Judge0ID:    92,
+		File:        "main.py",
+		Run:         []string{"python3", "main.py"},
+	},
+	{
+		Name:        "javascript",
+		DisplayName: "JavaScript (Node.js)",
+		Version:     "18.15.0",
+		Extension:   ".js",
+		Judge0ID:    93,
+		File:        "main.js",
+		Run:         []string{"node", "main.js"},
+	},
+	{
+		Name:        "java",
+		DisplayName: "Java",
+		Version:     "17.0.6",
+		Extension:   ".java",
+		Judge0ID:    91,
+		File:        "Main.java",
+		Compile:     []string{"javac", "Main.java"},
+		Run:         []string{"java", "Main"},
+	},
+}
+
+// Registry is a set of languages by name. It is not changed once the
+// server runs, so it is safe to share.
+type Registry struct {
+	languages map[string]Language
+}
+
+// Default returns the languages supported out of the box
+func Default() *Registry {
+	r := &Registry{languages: make(map[string]Language)}
+	for _, lang := range defaults {
+		r.languages[lang.Name] = lang
+	}
+	return r
+}
+
+// configFile is the format of a languages file
+type configFile struct {
+	Languages []Language `json:"languages"`
+}
+
+// Load returns the default languages together with those of the JSON
+// file at path. A language of the file replaces the default one of the
+// same name.
+func Load(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config configFile
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	r := Default()
+	for _, lang := range config.Languages {
+		if err := lang.validate(); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		r.languages[lang.Name] = lang
+	}
+	return r, nil
+}
+
+// Get returns the language called name
+func (r *Registry) Get(name string) (Language, error) {
+	lang, exists := r.languages[name]
+	if !exists {
+		return Language{}, ErrUnknownLanguage
+	}
+	return lang, nil
+}
+
+// List returns the languages sorted by name
+func (r *Registry) List() []Language {
+	list := make([]Language, 0, len(r.languages))
+	for _, lang := range r.languages {
+		list = append(list, lang)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Filter returns the languages keep accepts
+func (r *Registry) Filter(keep func(Language) bool) *Registry {
+	filtered := &Registry{languages: make(map[string]Language)}
+	for name, lang := range r.languages {
+		if keep(lang) {
+			filtered.languages[name] = lang
+		}
+	}
+	return filtered
+}
+
+// Judge0IDs maps the languages Judge0 can run to their IDs
+func (r *Registry) Judge0IDs() map[string]int {
+	ids := make(map[string]int)
+	for name, lang := range r.languages {
+		if lang.Judge0ID > 0 {
+			ids[name] = lang.Judge0ID
+		}
+	}
+	return ids
+}
+
+// SetJudge0IDs changes the Judge0 IDs of known languages
+func (r *Registry) SetJudge0IDs(ids map[string]int) error {
+	for name, id := range ids {
+		lang, exists := r.languages[name]
+		if !exists {
+			return fmt.Errorf("%w: %s", ErrUnknownLanguage, name)
+		}
+		lang.Judge0ID = id
+		r.languages[name] = lang
+	}
+	return nil
+}
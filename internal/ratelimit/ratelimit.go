@@ -0,0 +1,109 @@
+// Package ratelimit limits how often something may happen per key with
+// token buckets.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter allows perMinute events a minute per key, in bursts of up to
+// burst events
+type Limiter struct {
+	rate  float64 // tokens a second
+	burst float64
+	// Map of key -> bucket of the key
+	buckets map[string]*bucket
+	mutex   sync.Mutex
+}
+
+// New creates a limiter. A perMinute of zero or less allows everything.
+func New(perMinute, burst int) *Limiter {
+	return &Limiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token of key if there is one. Otherwise it reports how
+// long until there is.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := math.Ceil((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, time.Duration(wait)
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Refund gives back a token Allow took from key
+func (l *Limiter) Refund(key string) {
+	if l.rate <= 0 {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if b, exists := l.buckets[key]; exists {
+		b.tokens = min(l.burst, b.tokens+1)
+	}
+}
+
+// Reset refills the bucket of key
+func (l *Limiter) Reset(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.buckets, key)
+}
+
+// Prune forgets buckets that have filled up again
+func (l *Limiter) Prune() {
+	if l.rate <= 0 {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if time.Since(b.last) > refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RunPruning prunes the limiter on a ticker and blocks
+func (l *Limiter) RunPruning(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		l.Prune()
+	}
+}
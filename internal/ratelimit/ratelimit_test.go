@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	tests := []struct {
+		name      string
+		perMinute int
+		burst     int
+		calls     int
+		allowed   int
+	}{
+		{"unlimited", 0, 0, 100, 100},
+		{"burst", 60, 5, 10, 5},
+		{"burst of at least one", 60, 0, 3, 1},
+		{"under the burst", 60, 5, 3, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.perMinute, tt.burst)
+			allowed := 0
+			for range tt.calls {
+				ok, wait := l.Allow("key")
+				if ok {
+					allowed++
+					if wait != 0 {
+						t.Errorf("allowed with a wait of %v", wait)
+					}
+				} else if wait <= 0 || wait > time.Minute/time.Duration(tt.perMinute) {
+					t.Errorf("wait = %v, want up to %v", wait, time.Minute/time.Duration(tt.perMinute))
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d of %d calls, want %d", allowed, tt.calls, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestAllowKeysAreSeparate(t *testing.T) {
+	l := New(60, 1)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("first call of a was limited")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("second call of a was allowed")
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("b was limited by a's calls")
+	}
+}
+
+func TestAllowRefills(t *testing.T) {
+	l := New(600, 1) // a token every 100ms
+	if ok, _ := l.Allow("key"); !ok {
+		t.Fatal("first call was limited")
+	}
+	ok, wait := l.Allow("key")
+	if ok {
+		t.Fatal("second call was allowed")
+	}
+	time.Sleep(wait)
+	if ok, _ := l.Allow("key"); !ok {
+		t.Error("call after the wait was limited")
+	}
+}
+
+func TestReset(t *testing.T) {
+	l := New(60, 1)
+	l.Allow("key")
+	l.Reset("key")
+	if ok, _ := l.Allow("key"); !ok {
+		t.Error("call after a reset was limited")
+	}
+}
+
+func TestRefund(t *testing.T) {
+	tests := []struct {
+		name    string
+		burst   int
+		calls   int
+		refunds int
+		allowed bool
+	}{
+		{"gives a token back", 1, 1, 1, true},
+		{"caps at the burst", 1, 0, 3, true},
+		{"one token per refund", 2, 2, 1, true},
+		{"without a refund", 1, 1, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(60, tt.burst)
+			for range tt.calls {
+				l.Allow("key")
+			}
+			for range tt.refunds {
+				l.Refund("key")
+			}
+			if ok, _ := l.Allow("key"); ok != tt.allowed {
+				t.Errorf("allowed = %v, want %v", ok, tt.allowed)
+			}
+			if tt.calls == 0 {
+				// The burst is not exceeded by refunds
+				l.Allow("key")
+				if ok, _ := l.Allow("key"); ok {
+					t.Error("refunds went past the burst")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package sandbox
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+	linuxCapabilityV3    = 0x20080522
+
+	// SECBIT_NOROOT, SECBIT_NO_SETUID_FIXUP and SECBIT_KEEP_CAPS, all locked
+	securebits = 1<<0 | 1<<1 | 1<<2 | 1<<3 | 1<<5
+	// Used when /proc/sys/kernel/cap_last_cap cannot be read
+	defaultLastCap = 40
+)
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// dropCapabilities takes away the capabilities init holds in its user
+// namespace, so the steps cannot remount, trace or otherwise undo the
+// sandbox. Capabilities belong to a thread, so the caller must be locked
+// to the thread the steps are started from.
+func dropCapabilities() error {
+	for c := 0; c <= lastCap(); c++ {
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, syscall.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0, 0)
+		if errno != 0 && errno != syscall.EINVAL {
+			return errno
+		}
+	}
+	// Ambient capabilities only exist since Linux 4.3
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0)
+	if errno != 0 && errno != syscall.EINVAL {
+		return errno
+	}
+	// Being uid 0 in the namespace must not give the steps them back
+	_, _, errno = syscall.RawSyscall6(syscall.SYS_PRCTL, syscall.PR_SET_SECUREBITS, securebits, 0, 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	header := capHeader{version: linuxCapabilityV3}
+	var data [2]capData
+	_, _, errno = syscall.RawSyscall(syscall.SYS_CAPSET,
+		uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// lastCap is the highest capability the kernel knows
+func lastCap() int {
+	content, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return defaultLastCap
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return defaultLastCap
+	}
+	return last
+}
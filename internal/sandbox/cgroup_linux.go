@@ -0,0 +1,121 @@
+package sandbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Period of the cpu.max quota
+const cpuPeriod = 100000
+
+// cgroupRoot is the cgroup v2 directory runs get their cgroups under
+type cgroupRoot struct {
+	path string
+	next atomic.Int64
+}
+
+// cgroup limits a single run
+type cgroup struct {
+	path string
+	dir  *os.File
+}
+
+// newCgroupRoot creates the root and enables the controllers runs need.
+// The parent must already delegate cpu, memory and pids to it.
+func newCgroupRoot(path string) (*cgroupRoot, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	control := filepath.Join(path, "cgroup.subtree_control")
+	if err := os.WriteFile(control, []byte("+cpu +memory +pids"), 0); err != nil {
+		return nil, fmt.Errorf("enabling controllers in %s: %w", path, err)
+	}
+	return &cgroupRoot{path: path}, nil
+}
+
+func (r *cgroupRoot) create(limits Limits) (*cgroup, error) {
+	name := fmt.Sprintf("run-%d-%d", os.Getpid(), r.next.Add(1))
+	cg := &cgroup{path: filepath.Join(r.path, name)}
+	if err := os.Mkdir(cg.path, 0o755); err != nil {
+		return nil, err
+	}
+
+	settings := map[string]string{}
+	if limits.Memory > 0 {
+		settings["memory.max"] = strconv.FormatInt(limits.Memory, 10)
+		settings["memory.swap.max"] = "0"
+	}
+	if limits.CPUs > 0 {
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int(limits.CPUs*cpuPeriod), cpuPeriod)
+	}
+	if limits.Pids > 0 {
+		settings["pids.max"] = strconv.Itoa(limits.Pids)
+	}
+	for file, value := range settings {
+		err := os.WriteFile(filepath.Join(cg.path, file), []byte(value), 0)
+		if err != nil && !(file == "memory.swap.max" && errors.Is(err, os.ErrNotExist)) {
+			cg.remove()
+			return nil, fmt.Errorf("setting %s: %w", file, err)
+		}
+	}
+
+	dir, err := os.Open(cg.path)
+	if err != nil {
+		cg.remove()
+		return nil, err
+	}
+	cg.dir = dir
+	return cg, nil
+}
+
+// kill kills every process in the cgroup
+func (c *cgroup) kill() {
+	os.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0)
+}
+
+// oomKilled reports whether the memory limit killed any process
+func (c *cgroup) oomKilled() bool {
+	return c.oomKills() > 0
+}
+
+// oomKills counts the processes the memory limit killed
+func (c *cgroup) oomKills() int {
+	f, err := os.Open(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if count, ok := strings.CutPrefix(scanner.Text(), "oom_kill "); ok {
+			n, _ := strconv.Atoi(count)
+			return n
+		}
+	}
+	return 0
+}
+
+// remove kills what is left in the cgroup and deletes it
+func (c *cgroup) remove() {
+	if c.dir != nil {
+		c.dir.Close()
+	}
+	c.kill()
+	// The kernel needs a moment to reap killed processes
+	for i := 0; i < 50; i++ {
+		err := syscall.Rmdir(c.path)
+		if err == nil || errors.Is(err, syscall.ENOENT) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
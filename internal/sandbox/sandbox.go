@@ -0,0 +1,118 @@
+// Package sandbox runs untrusted commands in an isolated Linux environment:
+// fresh user, mount, PID, network, IPC and UTS namespaces, a root file
+// system holding only the host paths a run needs, read-only, and a tmpfs
+// work directory, a seccomp filter and cgroup v2 limits on CPU, memory and
+// processes.
+//
+// Programs using the package must call Init first thing in main, since
+// the sandbox is set up by a re-executed copy of the program.
+package sandbox
+
+import (
+	"errors"
+	"time"
+)
+
+// Where the tmpfs work directory is mounted inside the sandbox
+const WorkDir = "/tmp"
+
+// DefaultMounts are the system directories programs and the libraries
+// they load are found in. Paths a host does not have are skipped.
+var DefaultMounts = []string{
+	"/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32", "/usr",
+	"/etc/alternatives", "/etc/ld.so.cache",
+}
+
+var (
+	ErrUnsupported = errors.New("sandbox is only supported on linux")
+	ErrSetup       = errors.New("sandbox could not be set up")
+	ErrNoCgroup    = errors.New("a cgroup v2 root is required")
+)
+
+// File is written to the work directory before the first step runs
+type File struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// Step is one command of a run, e.g. compiling and then running a program.
+// Args[0] is looked up in the PATH of the sandbox.
+type Step struct {
+	Args    []string      `json:"args"`
+	Stdin   string        `json:"stdin"`
+	Timeout time.Duration `json:"timeout"`
+	// Read stdin from the caller of RunInteractive instead of Stdin
+	Interactive bool `json:"interactive"`
+	// Run the next steps even if this one fails
+	KeepGoing bool `json:"keep_going"`
+	// Run in the work directory as the steps before the first fresh step
+	// left it, with nothing earlier steps started still running. What a
+	// fresh step writes is not seen by the steps after it.
+	Fresh bool `json:"fresh"`
+}
+
+// Spec describes a sandboxed run. Steps run in order in the work
+// directory until one of them fails, unless it is set to keep going.
+type Spec struct {
+	Files []File   `json:"files"`
+	Steps []Step   `json:"steps"`
+	Env   []string `json:"env"`
+	// Absolute host paths bound read-only at the same place, such as the
+	// toolchains the steps run. Nothing else of the host is visible.
+	Mounts []string `json:"mounts"`
+	// Bytes of stdout and of stderr kept per step
+	OutputLimit int `json:"output_limit"`
+	// Size of the tmpfs holding the work directory, in bytes
+	WorkSize int64 `json:"work_size"`
+	// Memory limit of the run in bytes when lower than the sandbox's
+	Memory int64 `json:"memory"`
+}
+
+// Output receives what a step writes to stdout or stderr as it is
+// written, up to the spec's output limit. stream is "stdout" or "stderr".
+type Output func(step int, stream string, data []byte)
+
+// Finished receives the result of each step as soon as it exits
+type Finished func(step int, result StepResult)
+
+// Limits are enforced through cgroups on everything a run starts
+type Limits struct {
+	Memory int64   // bytes
+	CPUs   float64 // CPU time per wall clock second
+	Pids   int
+}
+
+// StepResult is the outcome of one step
+type StepResult struct {
+	Stdout    string        `json:"stdout"`
+	Stderr    string        `json:"stderr"`
+	ExitCode  int           `json:"exit_code"`
+	TimedOut  bool          `json:"timed_out"`
+	Truncated bool          `json:"truncated"`
+	CPUTime   time.Duration `json:"cpu_time"`
+	WallTime  time.Duration `json:"wall_time"`
+	MaxRSS    int64         `json:"max_rss"` // KB
+	// Only set for results passed to Finished
+	OOMKilled bool `json:"-"`
+}
+
+// Result has the results of the steps that ran, the last one being the
+// step that failed if any did
+type Result struct {
+	Steps     []StepResult
+	OOMKilled bool
+}
+
+// Last returns the result of the last step that ran
+func (r *Result) Last() StepResult {
+	if len(r.Steps) == 0 {
+		return StepResult{}
+	}
+	return r.Steps[len(r.Steps)-1]
+}
+
+// Success reports whether every step of spec ran and exited with 0
+func (r *Result) Success(spec *Spec) bool {
+	last := r.Last()
+	return len(r.Steps) == len(spec.Steps) && last.ExitCode == 0 && !last.TimedOut && !r.OOMKilled
+}
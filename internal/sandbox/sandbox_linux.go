@@ -0,0 +1,870 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Name the sandbox init process is started under, see Init
+const initArg = "codeeditor-sandbox-init"
+
+const (
+	defaultOutputLimit = 64 << 10
+	defaultWorkSize    = 64 << 20
+	// Time a step gets to exit after being killed before its output is
+	// abandoned
+	killGrace = time.Second
+)
+
+// Namespaces every run gets, cut off from the host's network, processes
+// and IPC
+const namespaces = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+	syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+
+// Sandbox runs specs with the given limits
+type Sandbox struct {
+	cgroups *cgroupRoot
+	limits  Limits
+}
+
+// New creates a sandbox whose runs are limited by cgroups created under
+// cgroupRoot, which must be in a delegated part of the cgroup v2 tree.
+// Runs are never started without those limits.
+func New(cgroupRoot string, limits Limits) (*Sandbox, error) {
+	if cgroupRoot == "" {
+		return nil, fmt.Errorf("%w: %v", ErrSetup, ErrNoCgroup)
+	}
+	root, err := newCgroupRoot(cgroupRoot)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSetup, err)
+	}
+	return &Sandbox{cgroups: root, limits: limits}, nil
+}
+
+// frame carries output of a step, or its result once it exits, from the
+// init process to Run
+type frame struct {
+	Step   int         `json:"step"`
+	Stream string      `json:"stream"`
+	Data   []byte      `json:"data"`
+	Result *StepResult `json:"result,omitempty"`
+}
+
+// Run executes spec in a fresh sandbox, passing output along as it is
+// produced if output is not nil. Cancelling ctx kills everything the run
+// started.
+func (s *Sandbox) Run(ctx context.Context, spec *Spec, output Output) (*Result, error) {
+	return s.run(ctx, spec, nil, output, nil)
+}
+
+// RunSteps is Run with finished getting the result of every step as soon
+// as it exits, for specs with many steps
+func (s *Sandbox) RunSteps(ctx context.Context, spec *Spec, output Output,
+	finished Finished) (*Result, error) {
+	return s.run(ctx, spec, nil, output, finished)
+}
+
+// RunInteractive is Run with the interactive steps of spec reading stdin.
+// stdin is read until it ends or the run does; a reader that blocks
+// should be closed by the caller once RunInteractive returns.
+func (s *Sandbox) RunInteractive(ctx context.Context, spec *Spec, stdin io.Reader,
+	output Output) (*Result, error) {
+	return s.run(ctx, spec, stdin, output, nil)
+}
+
+func (s *Sandbox) run(ctx context.Context, spec *Spec, stdin io.Reader, output Output,
+	finished Finished) (*Result, error) {
+	payload, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	resultR, resultW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer resultR.Close()
+	streamR, streamW, err := os.Pipe()
+	if err != nil {
+		resultW.Close()
+		return nil, err
+	}
+	defer streamR.Close()
+	extraFiles := []*os.File{resultW, streamW}
+	var inputR, inputW *os.File
+	if stdin != nil {
+		inputR, inputW, err = os.Pipe()
+		if err != nil {
+			resultW.Close()
+			streamW.Close()
+			return nil, err
+		}
+		defer inputW.Close()
+		extraFiles = append(extraFiles, inputR)
+	}
+
+	diagnostics := &limitedBuffer{limit: 4096}
+	cmd := &exec.Cmd{
+		Path:       "/proc/self/exe",
+		Args:       []string{initArg},
+		Env:        []string{},
+		Stdin:      bytes.NewReader(payload),
+		Stdout:     diagnostics,
+		Stderr:     diagnostics,
+		ExtraFiles: extraFiles,
+		SysProcAttr: &syscall.SysProcAttr{
+			Cloneflags: namespaces,
+			UidMappings: []syscall.SysProcIDMap{
+				{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+			},
+			GidMappings: []syscall.SysProcIDMap{
+				{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+			},
+			GidMappingsEnableSetgroups: false,
+			Pdeathsig:                  syscall.SIGKILL,
+		},
+	}
+
+	var cg *cgroup
+	if s.cgroups != nil {
+		limits := s.limits
+		if spec.Memory > 0 && (limits.Memory == 0 || spec.Memory < limits.Memory) {
+			limits.Memory = spec.Memory
+		}
+		cg, err = s.cgroups.create(limits)
+		if err != nil {
+			resultW.Close()
+			streamW.Close()
+			if inputR != nil {
+				inputR.Close()
+			}
+			return nil, fmt.Errorf("%w: %v", ErrSetup, err)
+		}
+		defer cg.remove()
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cg.dir.Fd())
+	}
+
+	err = cmd.Start()
+	resultW.Close()
+	streamW.Close()
+	if inputR != nil {
+		inputR.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSetup, err)
+	}
+	if stdin != nil {
+		go func() {
+			io.Copy(inputW, stdin)
+			inputW.Close()
+		}()
+	}
+
+	// Killing the init process takes its whole PID namespace down
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if cg != nil {
+				cg.kill()
+			}
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	steps := make(chan []StepResult, 1)
+	go func() {
+		var results []StepResult
+		if err := json.NewDecoder(resultR).Decode(&results); err != nil {
+			results = nil
+		}
+		steps <- results
+	}()
+
+	streamed := make(chan struct{})
+	go func() {
+		defer close(streamed)
+		reader := bufio.NewReader(streamR)
+		oomKills := 0
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				return
+			}
+			var f frame
+			if json.Unmarshal(line, &f) != nil {
+				continue
+			}
+			if f.Result == nil && output != nil {
+				output(f.Step, f.Stream, f.Data)
+			} else if f.Result != nil && finished != nil {
+				// The step was killed for memory if the count went up
+				if cg != nil {
+					kills := cg.oomKills()
+					f.Result.OOMKilled = kills > oomKills
+					oomKills = kills
+				}
+				finished(f.Step, *f.Result)
+			}
+		}
+	}()
+
+	waitErr := cmd.Wait()
+	results := <-steps
+	<-streamed
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	result := &Result{Steps: results}
+	if cg != nil {
+		result.OOMKilled = cg.oomKilled()
+	}
+	if results == nil && !result.OOMKilled {
+		return nil, fmt.Errorf("%w: %v: %s", ErrSetup, waitErr, strings.TrimSpace(diagnostics.String()))
+	}
+	return result, nil
+}
+
+// Init turns the process into the sandbox's init when it was started as
+// one by Run, and never returns in that case. Otherwise it does nothing.
+func Init() {
+	if len(os.Args) == 0 || os.Args[0] != initArg {
+		return
+	}
+	if err := runInit(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// runInit sets up the sandbox from inside the new namespaces, then runs
+// the steps, streaming their output to fd 4 and writing their results to
+// fd 3. Interactive steps read fd 5 if RunInteractive passed it.
+func runInit() error {
+	// Steps are started from this thread, which is the one that drops
+	// its capabilities
+	runtime.LockOSThread()
+
+	// The steps must not be able to write to Run's pipes
+	syscall.CloseOnExec(3)
+	syscall.CloseOnExec(4)
+	syscall.CloseOnExec(5)
+	results := os.NewFile(3, "results")
+	stream := &frameWriter{encoder: json.NewEncoder(os.NewFile(4, "stream"))}
+	var input *os.File
+	var st syscall.Stat_t
+	if syscall.Fstat(5, &st) == nil {
+		input = os.NewFile(5, "input")
+	}
+
+	var spec Spec
+	if err := json.NewDecoder(os.Stdin).Decode(&spec); err != nil {
+		return fmt.Errorf("reading spec: %w", err)
+	}
+	if spec.OutputLimit <= 0 {
+		spec.OutputLimit = defaultOutputLimit
+	}
+	if spec.WorkSize <= 0 {
+		spec.WorkSize = defaultWorkSize
+	}
+
+	if err := setupMounts(spec.Mounts, spec.WorkSize); err != nil {
+		return err
+	}
+	var snapshot *workSnapshot
+	if slices.ContainsFunc(spec.Steps, func(step Step) bool { return step.Fresh }) {
+		var err error
+		if snapshot, err = openSnapshot(spec.WorkSize); err != nil {
+			return err
+		}
+	}
+	if err := writeFiles(spec.Files); err != nil {
+		return err
+	}
+	if err := setupLimits(spec.WorkSize); err != nil {
+		return err
+	}
+	// Steps are looked up in and inherit the spec's environment
+	os.Clearenv()
+	for _, kv := range spec.Env {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			os.Setenv(key, value)
+		}
+	}
+	// The steps must not reach init's descriptors through /proc/1
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_DUMPABLE, 0, 0); errno != 0 {
+		return fmt.Errorf("making init not dumpable: %w", errno)
+	}
+	if err := dropCapabilities(); err != nil {
+		return fmt.Errorf("dropping capabilities: %w", err)
+	}
+	if err := installSeccomp(); err != nil {
+		return fmt.Errorf("installing seccomp filter: %w", err)
+	}
+
+	steps := make([]StepResult, 0, len(spec.Steps))
+	for i, step := range spec.Steps {
+		if step.Fresh {
+			if err := snapshot.restore(); err != nil {
+				return fmt.Errorf("resetting work directory: %w", err)
+			}
+		}
+		result := runStep(step, input, spec.OutputLimit, stream.forward(i))
+		steps = append(steps, result)
+		stream.finish(i, result)
+		if (result.ExitCode != 0 || result.TimedOut) && !step.KeepGoing {
+			break
+		}
+	}
+
+	return json.NewEncoder(results).Encode(steps)
+}
+
+// Where the new root is put together before pivot_root moves into it. A
+// directory every host has, hidden only inside the mount namespace.
+const newRoot = "/mnt"
+
+// Device nodes bound from the host into the sandbox's /dev
+var devices = []string{"null", "zero", "full", "random", "urandom"}
+
+// setupMounts builds a root file system holding only the host paths of
+// mounts, read-only, a fresh tmpfs work directory, /proc for the new PID
+// namespace and a few devices. It then moves into it with pivot_root and
+// drops the host's root, so nothing else of the host can be reached.
+func setupMounts(mounts []string, workSize int64) error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("making mounts private: %w", err)
+	}
+	// Mounts of the host below newRoot are hidden by it, but still listed
+	host, err := mountPoints()
+	if err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", newRoot, "tmpfs", syscall.MS_NOSUID, "size=1m,mode=755"); err != nil {
+		return fmt.Errorf("mounting new root: %w", err)
+	}
+
+	// Parents sort first, so paths inside them are seen to be bound
+	paths := slices.Clone(mounts)
+	for _, device := range devices {
+		paths = append(paths, "/dev/"+device)
+	}
+	slices.Sort(paths)
+	bound := make(map[string]bool)
+	for _, path := range paths {
+		if err := bindHost(path, bound); err != nil {
+			return fmt.Errorf("mounting %s: %w", path, err)
+		}
+	}
+	for i, name := range []string{"stdin", "stdout", "stderr"} {
+		if err := os.Symlink(fmt.Sprintf("/proc/self/fd/%d", i), newRoot+"/dev/"+name); err != nil {
+			return err
+		}
+	}
+	if err := readOnlyBinds(host); err != nil {
+		return err
+	}
+
+	// A fresh /proc can only be mounted while the host's is visible
+	if err := os.Mkdir(newRoot+"/proc", 0o555); err != nil {
+		return err
+	}
+	if err := syscall.Mount("proc", newRoot+"/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mounting /proc: %w", err)
+	}
+	if err := os.Mkdir(newRoot+WorkDir, 0o755); err != nil {
+		return err
+	}
+	options := fmt.Sprintf("size=%d,mode=1777", workSize)
+	if err := syscall.Mount("tmpfs", newRoot+WorkDir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, options); err != nil {
+		return fmt.Errorf("mounting work directory: %w", err)
+	}
+
+	const oldRoot = "/.old-root"
+	if err := os.Mkdir(newRoot+oldRoot, 0o700); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(newRoot, newRoot+oldRoot); err != nil {
+		return fmt.Errorf("pivoting root: %w", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+	if err := syscall.Unmount(oldRoot, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmounting host root: %w", err)
+	}
+	if err := os.Remove(oldRoot); err != nil {
+		return err
+	}
+	if err := remountReadOnly("/"); err != nil {
+		return err
+	}
+	return os.Chdir(WorkDir)
+}
+
+// bindHost binds the host path into the new root at the same place.
+// Paths that do not exist on the host or are inside a bound directory
+// are skipped, and symlinks such as /lib -> usr/lib on merged /usr hosts
+// are copied. Nothing is created through a symlink or inside a bound
+// directory, which would write to the host.
+func bindHost(path string, bound map[string]bool) error {
+	if !filepath.IsAbs(path) {
+		return errors.New("path is not absolute")
+	}
+	path = filepath.Clean(path)
+	if path == "/" {
+		return errors.New("the host root cannot be mounted")
+	}
+
+	dir := "/"
+	for _, name := range strings.Split(filepath.Dir(path), "/")[1:] {
+		if name == "" {
+			continue
+		}
+		dir = filepath.Join(dir, name)
+		if bound[dir] {
+			return nil
+		}
+		info, err := os.Lstat(newRoot + dir)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			if err := os.Mkdir(newRoot+dir, 0o755); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !info.IsDir():
+			// Inside a copied symlink, whatever it points to is bound
+			// on its own if at all
+			return nil
+		}
+	}
+	if bound[path] {
+		return nil
+	}
+
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	target := newRoot + path
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		bound[path] = true
+		return os.Symlink(link, target)
+	case info.IsDir():
+		if err := os.Mkdir(target, 0o755); err != nil {
+			return err
+		}
+	default:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+	bound[path] = true
+	return syscall.Mount(path, target, "", syscall.MS_BIND|syscall.MS_REC, "")
+}
+
+// readOnlyBinds makes everything bound into the new root read-only,
+// leaving the host mounts alone. The device nodes stay writable,
+// read-only mounts do not stop writes to them.
+func readOnlyBinds(host []mountPoint) error {
+	hidden := make(map[string]bool)
+	for _, mount := range host {
+		hidden[mount.id] = true
+	}
+	mounts, err := mountPoints()
+	if err != nil {
+		return err
+	}
+	for _, mount := range mounts {
+		if hidden[mount.id] || !strings.HasPrefix(mount.path, newRoot+"/") {
+			continue
+		}
+		if err := remountReadOnly(mount.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remountReadOnly makes a mount read-only
+func remountReadOnly(mount string) error {
+	// Flags a remount has to keep, as they may be locked by the host.
+	// The statfs ST_* flags share their values with MS_*.
+	const keep = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+		syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(mount, &st); err != nil {
+		return fmt.Errorf("inspecting %s: %w", mount, err)
+	}
+	flags := uintptr(syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY) | (uintptr(st.Flags) & keep)
+	if err := syscall.Mount("", mount, "", flags, ""); err != nil {
+		return fmt.Errorf("making %s read-only: %w", mount, err)
+	}
+	return nil
+}
+
+// mountPoint is a mount of the mount namespace
+type mountPoint struct {
+	id   string
+	path string
+}
+
+// mountPoints lists the mounts of the mount namespace, parents first
+func mountPoints() ([]mountPoint, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []mountPoint
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mounts = append(mounts, mountPoint{id: fields[0], path: unescapeMountPoint(fields[4])})
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPoint decodes the octal escapes mountinfo uses for spaces
+// and other special characters
+func unescapeMountPoint(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			var c byte
+			if _, err := fmt.Sscanf(s[i+1:i+4], "%03o", &c); err == nil {
+				b.WriteByte(c)
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func writeFiles(files []File) error {
+	for _, file := range files {
+		if !filepath.IsLocal(file.Path) {
+			return fmt.Errorf("file path %q is not valid", file.Path)
+		}
+		path := filepath.Join(WorkDir, file.Path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(file.Content), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// workSnapshot holds the work directory as it was before the first fresh
+// step, in a tmpfs no path leads to so that the steps cannot change it
+type workSnapshot struct {
+	dir   *os.File
+	taken bool
+}
+
+func openSnapshot(size int64) (*workSnapshot, error) {
+	// Mounted over the work directory and detached at once, the tmpfs is
+	// only reachable through dir
+	options := fmt.Sprintf("size=%d,mode=700", size)
+	if err := syscall.Mount("tmpfs", WorkDir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, options); err != nil {
+		return nil, fmt.Errorf("mounting work directory snapshot: %w", err)
+	}
+	dir, err := os.Open(WorkDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Unmount(WorkDir, syscall.MNT_DETACH); err != nil {
+		dir.Close()
+		return nil, fmt.Errorf("detaching work directory snapshot: %w", err)
+	}
+	return &workSnapshot{dir: dir}, nil
+}
+
+// restore kills what earlier steps left running, then takes the snapshot
+// the first time and puts the work directory back to it afterwards
+func (s *workSnapshot) restore() error {
+	if err := killLeftovers(); err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/proc/self/fd/%d", s.dir.Fd())
+	if !s.taken {
+		s.taken = true
+		return copyTree(path, WorkDir)
+	}
+	if err := clearDir(WorkDir); err != nil {
+		return err
+	}
+	return copyTree(WorkDir, path)
+}
+
+// killLeftovers kills every process of the PID namespace but init, such
+// as daemons a step started in another process group, and reaps them
+func killLeftovers() error {
+	if err := syscall.Kill(-1, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	// Orphans are reparented to init, so it waits for all of them
+	for {
+		_, err := syscall.Wait4(-1, nil, 0, nil)
+		if err == syscall.ECHILD {
+			return nil
+		}
+		if err != nil && err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// clearDir removes everything in dir, including what a step made
+// read-only
+func clearDir(dir string) error {
+	if err := os.Chmod(dir, 0o700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if err := clearDir(path); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTree copies the directories, regular files and symlinks in src to
+// the existing directory dst and gives dst the mode of src. Anything
+// else, like sockets, is left out.
+func copyTree(dst, src string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		from, to := filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())
+		switch {
+		case entry.IsDir():
+			if err := os.Mkdir(to, 0o700); err != nil {
+				return err
+			}
+			err = copyTree(to, from)
+		case entry.Type()&os.ModeSymlink != 0:
+			var target string
+			if target, err = os.Readlink(from); err == nil {
+				err = os.Symlink(target, to)
+			}
+		case entry.Type().IsRegular():
+			err = copyFile(to, from)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return os.Chmod(dst, info.Mode()&(os.ModePerm|os.ModeSticky))
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, info.Mode().Perm())
+}
+
+// setupLimits sets the resource limits cgroups do not cover
+func setupLimits(workSize int64) error {
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+		{syscall.RLIMIT_CORE, 0},
+		{syscall.RLIMIT_FSIZE, uint64(workSize)},
+	}
+	for _, limit := range limits {
+		rlimit := syscall.Rlimit{Cur: limit.value, Max: limit.value}
+		if err := syscall.Setrlimit(limit.resource, &rlimit); err != nil {
+			return fmt.Errorf("setting rlimit %d: %w", limit.resource, err)
+		}
+	}
+	return nil
+}
+
+func runStep(step Step, input *os.File, outputLimit int, forward func(stream string) func([]byte)) StepResult {
+	ctx := context.Background()
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, step.Timeout)
+		defer cancel()
+	}
+
+	var result StepResult
+	if len(step.Args) == 0 {
+		result.ExitCode = 127
+		result.Stderr = "empty command"
+		return result
+	}
+
+	stdout := &limitedBuffer{limit: outputLimit, forward: forward("stdout")}
+	stderr := &limitedBuffer{limit: outputLimit, forward: forward("stderr")}
+	cmd := exec.CommandContext(ctx, step.Args[0], step.Args[1:]...)
+	cmd.Dir = WorkDir
+	cmd.Stdin = strings.NewReader(step.Stdin)
+	if step.Interactive && input != nil {
+		cmd.Stdin = input
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Kill the whole process group, e.g. the compiler a build started
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = killGrace
+
+	start := time.Now()
+	err := cmd.Run()
+	result.WallTime = time.Since(start)
+	result.TimedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+
+	if state := cmd.ProcessState; state != nil {
+		result.CPUTime = state.UserTime() + state.SystemTime()
+		result.ExitCode = state.ExitCode()
+		if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+			result.MaxRSS = usage.Maxrss
+		}
+		if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			result.ExitCode = 128 + int(status.Signal())
+		}
+	} else if err != nil {
+		// The command could not be started, like a shell would report it
+		result.ExitCode = 127
+		stderr.WriteString(err.Error())
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.Truncated = stdout.truncated || stderr.truncated
+	return result
+}
+
+// frameWriter sends output frames to Run. Writes of stdout and stderr
+// come from different goroutines.
+type frameWriter struct {
+	encoder *json.Encoder
+	mutex   sync.Mutex
+}
+
+// forward returns the functions passing on output of a step
+func (w *frameWriter) forward(step int) func(stream string) func([]byte) {
+	return func(stream string) func([]byte) {
+		return func(data []byte) {
+			w.mutex.Lock()
+			defer w.mutex.Unlock()
+			w.encoder.Encode(frame{Step: step, Stream: stream, Data: data})
+		}
+	}
+}
+
+// finish sends the result of a step
+func (w *frameWriter) finish(step int, result StepResult) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.encoder.Encode(frame{Step: step, Result: &result})
+}
+
+// limitedBuffer keeps the first limit bytes written to it and drops the
+// rest, so a noisy program cannot exhaust memory. What is kept is also
+// passed to forward, if set. The buffer is not embedded, as io.Copy would
+// use its ReadFrom and get around the limit.
+type limitedBuffer struct {
+	buffer    bytes.Buffer
+	limit     int
+	truncated bool
+	forward   func([]byte)
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	kept := p
+	if room := b.limit - b.buffer.Len(); room < len(p) {
+		b.truncated = true
+		kept = p[:max(room, 0)]
+	}
+	b.buffer.Write(kept)
+	if b.forward != nil && len(kept) > 0 {
+		b.forward(kept)
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) WriteString(s string) (int, error) {
+	return b.Write([]byte(s))
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buffer.String()
+}
+
+var _ io.Writer = (*limitedBuffer)(nil)
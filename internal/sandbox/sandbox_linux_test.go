@@ -0,0 +1,113 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	Init()
+	os.Exit(m.Run())
+}
+
+// run runs script with sh in a sandbox without cgroup limits, which the
+// test host may not delegate
+func run(t *testing.T, script string) StepResult {
+	t.Helper()
+	spec := &Spec{
+		Steps:  []Step{{Args: []string{"sh", "-c", script}}},
+		Env:    []string{"PATH=/usr/bin:/bin"},
+		Mounts: DefaultMounts,
+	}
+	result, err := (&Sandbox{}).Run(context.Background(), spec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Last()
+}
+
+// userNamespaces reports whether unprivileged user namespaces are enabled
+func userNamespaces() bool {
+	for _, setting := range []string{"/proc/sys/user/max_user_namespaces", "/proc/sys/kernel/unprivileged_userns_clone"} {
+		value, err := os.ReadFile(setting)
+		if err == nil && strings.TrimSpace(string(value)) == "0" {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRootHidesHost(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !userNamespaces() {
+		t.Skip("user namespaces are disabled")
+	}
+	if _, err := os.Stat("/etc/passwd"); err != nil {
+		t.Skip("host has no /etc/passwd")
+	}
+
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{"server working directory", "test ! -e " + filepath.Join(wd, "sandbox.go")},
+		{"working directory of init", "test ! -e /proc/1/cwd/sandbox.go"},
+		{"root of init", "test ! -e /proc/1/root" + filepath.Join(wd, "sandbox.go")},
+		{"descriptors of init", "for fd in /proc/1/fd/*; do test ! -e $fd || exit 1; done"},
+		{"host /etc/passwd", "test ! -e /etc/passwd"},
+		{"starts in the work directory", `test "$(pwd)" = ` + WorkDir},
+		{"work directory is writable", "echo ok > out && test -s out"},
+		{"toolchains are read-only", "! touch /usr/sandbox-test"},
+		{"root is read-only", "! mkdir /sandbox-test"},
+		{"devices work", "echo ok > /dev/null && head -c 1 /dev/urandom > /dev/null"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := run(t, tt.script)
+			if result.ExitCode != 0 {
+				t.Errorf("%q exited with %d: %s", tt.script, result.ExitCode, result.Stderr)
+			}
+		})
+	}
+}
+
+func TestFreshSteps(t *testing.T) {
+	if !userNamespaces() {
+		t.Skip("user namespaces are disabled")
+	}
+
+	sh := func(script string, fresh bool) Step {
+		return Step{Args: []string{"sh", "-c", script}, KeepGoing: true, Fresh: fresh}
+	}
+	spec := &Spec{
+		Steps: []Step{
+			sh("mkdir bin && echo built > bin/artifact", false),
+			sh("echo changed > bin/artifact && echo case > left && chmod 000 bin && "+
+				"setsid sh -c 'sleep 0.5; chmod 755 bin; echo late > late' >/dev/null 2>&1 &", true),
+			sh("sleep 1; test ! -e left && test ! -e late && grep -qx built bin/artifact", true),
+			sh("echo not fresh > left", false),
+			sh("test -e left", false),
+		},
+		Env:    []string{"PATH=/usr/bin:/bin"},
+		Mounts: DefaultMounts,
+	}
+	result, err := (&Sandbox{}).Run(context.Background(), spec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Steps) != len(spec.Steps) {
+		t.Fatalf("ran %d of %d steps", len(result.Steps), len(spec.Steps))
+	}
+	for i, step := range result.Steps {
+		if step.ExitCode != 0 {
+			t.Errorf("step %d exited with %d: %s", i, step.ExitCode, step.Stderr)
+		}
+	}
+}
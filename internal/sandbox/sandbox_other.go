@@ -0,0 +1,33 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"context"
+	"io"
+)
+
+// Sandbox runs specs with the given limits
+type Sandbox struct{}
+
+// New fails outside linux
+func New(cgroupRoot string, limits Limits) (*Sandbox, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *Sandbox) Run(ctx context.Context, spec *Spec, output Output) (*Result, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *Sandbox) RunInteractive(ctx context.Context, spec *Spec, stdin io.Reader,
+	output Output) (*Result, error) {
+	return nil, ErrUnsupported
+}
+
+func (s *Sandbox) RunSteps(ctx context.Context, spec *Spec, output Output,
+	finished Finished) (*Result, error) {
+	return nil, ErrUnsupported
+}
+
+// Init does nothing outside linux
+func Init() {}
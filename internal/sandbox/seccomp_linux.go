@@ -0,0 +1,108 @@
+package sandbox
+
+import (
+	"errors"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prSetNoNewPrivs       = 38
+	seccompSetModeFilter  = 1
+	seccompFilterFlagSync = 1 // SECCOMP_FILTER_FLAG_TSYNC
+
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetAllow       = 0x7fff0000
+
+	// Offsets into struct seccomp_data
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArg0 = 16 // low half on little endian
+
+	sysClone3 = 435 // same number on every architecture
+
+	cloneNewCgroup = 0x02000000
+)
+
+// Flags that create namespaces; clone is refused when it asks for any
+const cloneNamespaceFlags = syscall.CLONE_NEWNS | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC |
+	syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET | cloneNewCgroup
+
+var errNoSeccomp = errors.New("seccomp is not supported on this architecture")
+
+func bpfStmt(code uint16, k uint32) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// seccompFilter denies the syscalls in deniedSyscalls along with clone
+// calls that create namespaces. Everything else is allowed, as compilers
+// and runtimes need a wide range of syscalls. clone3 gets ENOSYS since its
+// flags cannot be inspected, so libc falls back to clone.
+func seccompFilter() []syscall.SockFilter {
+	const (
+		ld   = syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS
+		jeq  = syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K
+		jge  = syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K
+		jset = syscall.BPF_JMP | syscall.BPF_JSET | syscall.BPF_K
+		ret  = syscall.BPF_RET | syscall.BPF_K
+	)
+	eperm := seccompRetErrno | uint32(syscall.EPERM)
+
+	filter := []syscall.SockFilter{
+		bpfStmt(ld, seccompDataArch),
+		bpfJump(jeq, auditArch, 1, 0),
+		bpfStmt(ret, seccompRetKillProcess),
+		bpfStmt(ld, seccompDataNr),
+	}
+	if syscallNrLimit != 0 {
+		// e.g. the x32 ABI on amd64
+		filter = append(filter,
+			bpfJump(jge, syscallNrLimit, 0, 1),
+			bpfStmt(ret, eperm),
+		)
+	}
+	for _, nr := range deniedSyscalls {
+		filter = append(filter,
+			bpfJump(jeq, nr, 0, 1),
+			bpfStmt(ret, eperm),
+		)
+	}
+	return append(filter,
+		bpfJump(jeq, sysClone3, 0, 1),
+		bpfStmt(ret, seccompRetErrno|uint32(syscall.ENOSYS)),
+		bpfJump(jeq, sysClone, 0, 3),
+		bpfStmt(ld, seccompDataArg0),
+		bpfJump(jset, cloneNamespaceFlags, 0, 1),
+		bpfStmt(ret, eperm),
+		bpfStmt(ret, seccompRetAllow),
+	)
+}
+
+// installSeccomp applies the filter to every thread of the process and
+// everything it starts afterwards
+func installSeccomp() error {
+	if auditArch == 0 {
+		return errNoSeccomp
+	}
+	filter := seccompFilter()
+	prog := syscall.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return errno
+	}
+	_, _, errno := syscall.RawSyscall(sysSeccomp, seccompSetModeFilter, seccompFilterFlagSync,
+		uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build linux && !amd64 && !arm64
+
+package sandbox
+
+// Seccomp filters are only built for amd64 and arm64; runs fail elsewhere
+const (
+	auditArch      = 0
+	sysSeccomp     = 0
+	sysClone       = 0
+	syscallNrLimit = 0
+)
+
+var deniedSyscalls []uint32
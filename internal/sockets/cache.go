@@ -0,0 +1,325 @@
+package sockets
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/languages"
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+)
+
+// Results of the cache
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+const (
+	DefaultCacheSize = 1024
+	// Results persisted in Postgres are used for this long
+	cacheTTL = 7 * 24 * time.Hour
+	// Longest a cache lookup or write may delay an execution
+	cacheTimeout = 2 * time.Second
+)
+
+// ResultCache keeps the results of runs by a hash of what was run, the
+// most recently used in memory and all of them in db if it is not nil.
+// Only results that would come out the same again are kept: successful
+// runs and compile errors of cacheable languages, but not timeouts,
+// executor failures or runs that came close to their time limit.
+type ResultCache struct {
+	size    int
+	db      *store.Storage
+	entries map[string]*list.Element
+	order   *list.List // front is most recently used
+	mutex   sync.Mutex
+}
+
+type cacheEntry struct {
+	key      string
+	response ExecuteResponse
+}
+
+func NewResultCache(size int, db *store.Storage) *ResultCache {
+	return &ResultCache{
+		size:    max(size, 1),
+		db:      db,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// cacheKey hashes everything a run's result depends on: the executor, how
+// it builds and runs the language and the run itself
+func cacheKey(kind string, lang languages.Language, req ExecuteRequest) string {
+	definition, _ := json.Marshal(lang)
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%d\x00%d\x00%d\x00%s\x00%d\x00%s",
+		kind, definition, req.Language, req.TimeLimit, req.MemoryLimit,
+		len(req.Code), req.Code, len(req.Input), req.Input)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// cacheable reports whether a result of a run with the given time limit
+// may be cached. A run that took half its limit or more could time out
+// the next time.
+func cacheable(response *ExecuteResponse, timeLimit time.Duration) bool {
+	if response.Status == "compile_error" {
+		return true
+	}
+	if response.Status != "success" {
+		return false
+	}
+	runtime, err := strconv.ParseFloat(response.Runtime, 64)
+	if timeLimit > 0 && (err != nil || runtime*float64(time.Second) >= float64(timeLimit)/2) {
+		return false
+	}
+	return true
+}
+
+func (c *ResultCache) get(ctx context.Context, key string) (*ExecuteResponse, bool) {
+	c.mutex.Lock()
+	if elem, exists := c.entries[key]; exists {
+		c.order.MoveToFront(elem)
+		response := elem.Value.(*cacheEntry).response
+		c.mutex.Unlock()
+		return &response, true
+	}
+	c.mutex.Unlock()
+
+	if c.db == nil {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, cacheTimeout)
+	defer cancel()
+	raw, err := c.db.CacheStore.Get(ctx, key, time.Now().Add(-cacheTTL))
+	if err != nil {
+		if err != store.ErrNotFound {
+			log.Printf("Error reading execution cache: %v", err)
+		}
+		return nil, false
+	}
+	var response ExecuteResponse
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return nil, false
+	}
+	c.remember(key, response)
+	return &response, true
+}
+
+func (c *ResultCache) put(ctx context.Context, key string, response ExecuteResponse) {
+	response.Cache = ""
+	c.remember(key, response)
+
+	if c.db == nil {
+		return
+	}
+	raw, _ := json.Marshal(response)
+	ctx, cancel := context.WithTimeout(ctx, cacheTimeout)
+	defer cancel()
+	if err := c.db.CacheStore.Put(ctx, key, raw); err != nil {
+		log.Printf("Error writing execution cache: %v", err)
+	}
+}
+
+// remember keeps a response in memory, evicting the least recently used
+func (c *ResultCache) remember(key string, response ExecuteResponse) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, exists := c.entries[key]; exists {
+		elem.Value.(*cacheEntry).response = response
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, response: response})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// RunPruning deletes expired results from db on a ticker and blocks
+func (c *ResultCache) RunPruning(interval time.Duration) {
+	if c.db == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+		if err := c.db.CacheStore.Prune(ctx, time.Now().Add(-cacheTTL)); err != nil {
+			log.Printf("Error pruning execution cache: %v", err)
+		}
+		cancel()
+	}
+}
+
+// CachingExecutor answers runs from a ResultCache when it can and passes
+// them on to another executor otherwise. Only runs of cacheable languages
+// are cached; interactive runs and those with NoCache set always go to
+// the executor.
+type CachingExecutor struct {
+	executor  Executor
+	cache     *ResultCache
+	kind      string              // executor the results come from
+	languages *languages.Registry // languages as the executor runs them
+}
+
+// cachingInteractiveExecutor keeps the executor's interactive runs
+// available through the cache
+type cachingInteractiveExecutor struct {
+	*CachingExecutor
+	interactive InteractiveExecutor
+}
+
+// NewCachingExecutor puts cache in front of executor, an executor of the
+// given kind running the languages of registry. Changing either of them
+// leaves the results cached before behind. The result can run
+// interactively if executor can.
+func NewCachingExecutor(executor Executor, kind string, registry *languages.Registry,
+	cache *ResultCache) Executor {
+	caching := &CachingExecutor{executor: executor, cache: cache, kind: kind, languages: registry}
+	if interactive, ok := executor.(InteractiveExecutor); ok {
+		return &cachingInteractiveExecutor{CachingExecutor: caching, interactive: interactive}
+	}
+	return caching
+}
+
+// key is the cache key of req, false if its results are not cached.
+// Unknown languages are not cached, they fail in the executor.
+func (e *CachingExecutor) key(req ExecuteRequest) (string, bool) {
+	lang, err := e.languages.Get(req.Language)
+	if err != nil || !lang.Cacheable || req.Interactive {
+		return "", false
+	}
+	return cacheKey(e.kind, lang, req), true
+}
+
+// timeLimit is the time limit req runs with, zero if only the executor
+// knows it
+func (e *CachingExecutor) timeLimit(req ExecuteRequest) time.Duration {
+	if req.TimeLimit > 0 {
+		return req.TimeLimit
+	}
+	lang, _ := e.languages.Get(req.Language)
+	return lang.TimeLimit()
+}
+
+func (e *CachingExecutor) ExecuteCode(ctx context.Context, req ExecuteRequest) (*ExecuteResponse, error) {
+	return e.ExecuteStream(ctx, req, nil)
+}
+
+// ExecuteStream passes a cached result's output on all at once
+func (e *CachingExecutor) ExecuteStream(ctx context.Context, req ExecuteRequest,
+	output OutputFunc) (*ExecuteResponse, error) {
+	key, cached := e.key(req)
+	if cached && !req.NoCache {
+		if response, ok := e.cache.get(ctx, key); ok {
+			response.Cache = CacheHit
+			if output != nil {
+				output("stdout", response.Output)
+				output("stderr", response.Error)
+			}
+			return response, nil
+		}
+	}
+
+	var response *ExecuteResponse
+	var err error
+	if streaming, ok := e.executor.(StreamingExecutor); ok {
+		response, err = streaming.ExecuteStream(ctx, req, output)
+	} else {
+		response, err = e.executor.ExecuteCode(ctx, req)
+		if err == nil && output != nil {
+			output("stdout", response.Output)
+			output("stderr", response.Error)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cached && cacheable(response, e.timeLimit(req)) {
+		e.cache.put(ctx, key, *response)
+	}
+	if cached && !req.NoCache {
+		response.Cache = CacheMiss
+	}
+	return response, nil
+}
+
+// ExecuteBatch answers the cases from the cache if it has all of them.
+// Otherwise they all run, in one batch if the executor can.
+func (e *CachingExecutor) ExecuteBatch(ctx context.Context, req ExecuteRequest, cases []BatchCase,
+	done func(i int, response *ExecuteResponse)) error {
+	// The cases differ only in input and limits, so they are all
+	// cached or none is
+	keys := make([]string, len(cases))
+	var cached bool
+	for i, c := range cases {
+		keys[i], cached = e.key(c.request(req))
+	}
+	if cached && !req.NoCache {
+		hits := make([]*ExecuteResponse, 0, len(cases))
+		for _, key := range keys {
+			response, ok := e.cache.get(ctx, key)
+			if !ok {
+				break
+			}
+			response.Cache = CacheHit
+			hits = append(hits, response)
+		}
+		if len(hits) == len(cases) {
+			for i, response := range hits {
+				done(i, response)
+			}
+			return nil
+		}
+	}
+
+	finish := func(i int, response *ExecuteResponse) {
+		if cached && cacheable(response, e.timeLimit(cases[i].request(req))) {
+			e.cache.put(ctx, keys[i], *response)
+		}
+		if cached && !req.NoCache {
+			response.Cache = CacheMiss
+		}
+		done(i, response)
+	}
+	if batch, ok := e.executor.(BatchExecutor); ok {
+		return batch.ExecuteBatch(ctx, req, cases, finish)
+	}
+	var buildFailed *ExecuteResponse
+	for i, c := range cases {
+		if buildFailed != nil {
+			done(i, buildFailed)
+			continue
+		}
+		response, err := e.executor.ExecuteCode(ctx, c.request(req))
+		if err != nil {
+			return err
+		}
+		finish(i, response)
+		if response.Status == "compile_error" {
+			buildFailed = response
+		}
+	}
+	return nil
+}
+
+func (e *cachingInteractiveExecutor) ExecuteInteractive(ctx context.Context, req ExecuteRequest,
+	stdin io.Reader, output OutputFunc) (*ExecuteResponse, error) {
+	return e.interactive.ExecuteInteractive(ctx, req, stdin, output)
+}
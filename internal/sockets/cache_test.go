@@ -0,0 +1,73 @@
+package sockets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/languages"
+)
+
+// countingExecutor answers every run with response and counts the runs
+type countingExecutor struct {
+	response ExecuteResponse
+	runs     int
+}
+
+func (e *countingExecutor) ExecuteCode(ctx context.Context, req ExecuteRequest) (*ExecuteResponse, error) {
+	e.runs++
+	response := e.response
+	return &response, nil
+}
+
+func TestCachingExecutor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "languages.json")
+	config := `{"languages": [{"name": "pure", "judge0_id": 1, "cacheable": true, "time_limit_ms": 1000}]}`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	registry, err := languages.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		req      ExecuteRequest
+		response ExecuteResponse
+		runs     int
+		cache    string
+	}{
+		{"cacheable language", ExecuteRequest{Language: "pure"}, ExecuteResponse{Status: "success", Runtime: "0.1"}, 1, CacheHit},
+		{"compile error", ExecuteRequest{Language: "pure"}, ExecuteResponse{Status: "compile_error"}, 1, CacheHit},
+		{"language not cacheable", ExecuteRequest{Language: "python"}, ExecuteResponse{Status: "success", Runtime: "0.1"}, 2, ""},
+		{"interactive", ExecuteRequest{Language: "pure", Interactive: true}, ExecuteResponse{Status: "success", Runtime: "0.1"}, 2, ""},
+		{"no cache", ExecuteRequest{Language: "pure", NoCache: true}, ExecuteResponse{Status: "success", Runtime: "0.1"}, 2, ""},
+		{"close to the time limit", ExecuteRequest{Language: "pure"}, ExecuteResponse{Status: "success", Runtime: "0.6"}, 2, CacheMiss},
+		{"close to the request's limit", ExecuteRequest{Language: "pure", TimeLimit: 100 * time.Millisecond}, ExecuteResponse{Status: "success", Runtime: "0.1"}, 2, CacheMiss},
+		{"timeout", ExecuteRequest{Language: "pure"}, ExecuteResponse{Status: "timeout"}, 2, CacheMiss},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := &countingExecutor{response: tt.response}
+			caching := NewCachingExecutor(executor, "test", registry, NewResultCache(8, nil))
+
+			var response *ExecuteResponse
+			for range 2 {
+				var err error
+				if response, err = caching.ExecuteCode(context.Background(), tt.req); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if executor.runs != tt.runs {
+				t.Errorf("runs = %d, want %d", executor.runs, tt.runs)
+			}
+			if response.Cache != tt.cache {
+				t.Errorf("cache = %q, want %q", response.Cache, tt.cache)
+			}
+		})
+	}
+}
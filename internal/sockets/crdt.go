@@ -0,0 +1,750 @@
+package sockets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+)
+
+// Ops parked while waiting for the element they reference
+const maxPendingOps = 10000
+
+// How long an op may wait for the element it references before it is
+// dropped and its site has to resync
+const crdtPendingTimeout = 30 * time.Second
+
+// Site of the ops generated by the server itself
+const crdtServerSite = "server"
+
+const (
+	crdtInsertKind = "insert"
+	crdtDeleteKind = "delete"
+)
+
+var (
+	ErrBadCRDTOp     = errors.New("malformed crdt operation")
+	ErrForeignSite   = errors.New("crdt operation is not from your site")
+	ErrTooManyOps    = errors.New("too many operations waiting for their dependencies")
+	ErrCRDTCompacted = errors.New("document was compacted, resync required")
+	ErrCRDTExpired   = errors.New("operations waited too long for their dependencies, resync required")
+)
+
+// ElementID identifies a CRDT operation by the Lamport clock and the site
+// (client session) that generated it. IDs are totally ordered.
+// A user's site is their user id, see crdtSite.
+type ElementID struct {
+	Clock int    `json:"clock"`
+	Site  string `json:"site"`
+}
+
+func (id ElementID) less(other ElementID) bool {
+	if id.Clock != other.Clock {
+		return id.Clock < other.Clock
+	}
+	return id.Site < other.Site
+}
+
+// CRDTOp is a single operation on an RGA sequence. Inserts add one
+// character right of Origin (nil for the start of the document), deletes
+// tombstone the element Target. Clients stamp ops with a Lamport clock,
+// one more than the highest clock they have seen, and send each site's
+// ops in clock order.
+type CRDTOp struct {
+	ID     ElementID  `json:"id"`
+	Kind   string     `json:"kind"`
+	Origin *ElementID `json:"origin,omitempty"`
+	Target *ElementID `json:"target,omitempty"`
+	Value  string     `json:"value,omitempty"`
+}
+
+// CRDTUpdateData is the payload of "crdt-update" messages. Clients may
+// send the state vector of their replica along to acknowledge the ops
+// they have integrated, like a "crdt-sync" does.
+type CRDTUpdateData struct {
+	File        string         `json:"file"`
+	Ops         []CRDTOp       `json:"ops"`
+	Revision    int            `json:"revision,omitempty"`
+	StateVector map[string]int `json:"state_vector,omitempty"`
+}
+
+// CRDTSyncData is the payload of "crdt-sync" messages. Clients send their
+// state vector and get back every op they are missing along with the
+// server's state vector, so they can push their own unseen ops. The state
+// vector also acknowledges the ops the client has, clients should sync
+// once they go idle. A client that missed a compaction, by its state
+// vector or by the number of compactions its replica went through, gets
+// the whole op log with Reset set and has to rebuild its replica from it.
+type CRDTSyncData struct {
+	File        string         `json:"file"`
+	StateVector map[string]int `json:"state_vector"`
+	Compactions int            `json:"compactions,omitempty"`
+	Ops         []CRDTOp       `json:"ops,omitempty"`
+	Revision    int            `json:"revision,omitempty"`
+	Reset       bool           `json:"reset,omitempty"`
+}
+
+// CRDTCompactData is the payload of "crdt-compact" messages, telling
+// clients which tombstones the server dropped so they drop them too
+type CRDTCompactData struct {
+	File        string      `json:"file"`
+	Removed     []ElementID `json:"removed"`
+	Revision    int         `json:"revision"`
+	Compactions int         `json:"compactions"`
+}
+
+// crdtState is the engine state of a CRDT snapshot. Older snapshots hold
+// just the op log.
+type crdtState struct {
+	Ops         []CRDTOp       `json:"ops"`
+	StateVector map[string]int `json:"state_vector"`
+	Compacted   map[string]int `json:"compacted,omitempty"`
+	Compactions int            `json:"compactions,omitempty"`
+}
+
+// pendingCRDTOp is an op parked while waiting for its dependencies
+type pendingCRDTOp struct {
+	op     CRDTOp
+	parked time.Time
+}
+
+type crdtElement struct {
+	ID      ElementID
+	Value   rune
+	Deleted bool
+}
+
+// CRDTDocument keeps a room's code buffer as a replicated growable array.
+// Clients can edit offline and merge later: ops commute, so every replica
+// that has seen the same set of ops holds the same text.
+type CRDTDocument struct {
+	RoomID      int64
+	Path        string
+	elements    []*crdtElement
+	byID        map[ElementID]*crdtElement
+	log         []CRDTOp // integrated ops in causal order
+	base        int      // revisions logged before the ops in log
+	stateVector map[string]int
+	compacted   map[string]int // state vector at the last compaction
+	compactions int
+	clock       int // highest clock seen
+	pending     []pendingCRDTOp
+	// Sites whose stuck ops were dropped, refused until they resync
+	expired map[string]bool
+	// State vector each connected client last reported
+	acked       map[*Connection]map[string]int
+	checkpoints checkpointer
+	mutex       sync.Mutex
+}
+
+func NewCRDTDocument(roomID int64, path string) *CRDTDocument {
+	return &CRDTDocument{
+		RoomID:      roomID,
+		Path:        path,
+		byID:        make(map[ElementID]*crdtElement),
+		stateVector: make(map[string]int),
+		compacted:   make(map[string]int),
+		expired:     make(map[string]bool),
+		acked:       make(map[*Connection]map[string]int),
+	}
+}
+
+// crdtSite is the site of a user's ops. Sessions of the same user may
+// tell their ops apart with a suffix, as in "42:tab-2".
+func crdtSite(userID int64) string {
+	return strconv.FormatInt(userID, 10)
+}
+
+// ownsSite reports whether ops of site may come from the user of userSite
+func ownsSite(userSite, site string) bool {
+	return site == userSite || strings.HasPrefix(site, userSite+":")
+}
+
+func (d *CRDTDocument) Engine() string {
+	return EngineCRDT
+}
+
+// Content returns the visible text; the revision is the number of ops
+// integrated so far.
+func (d *CRDTDocument) Content() (string, int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.text(), d.revision()
+}
+
+// revision is the latest revision of the document. The caller must hold
+// d.mutex.
+func (d *CRDTDocument) revision() int {
+	return d.base + len(d.log)
+}
+
+// Snapshot stores the op log as the engine state so offline clients can
+// still merge against a reloaded document.
+func (d *CRDTDocument) Snapshot() *store.Document {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	state, _ := json.Marshal(crdtState{
+		Ops:         d.log,
+		StateVector: d.stateVector,
+		Compacted:   d.compacted,
+		Compactions: d.compactions,
+	})
+	return &store.Document{
+		RoomId:   d.RoomID,
+		Path:     d.Path,
+		Content:  d.text(),
+		Revision: d.revision(),
+		Engine:   EngineCRDT,
+		State:    state,
+	}
+}
+
+func (d *CRDTDocument) text() string {
+	runes := make([]rune, 0, len(d.elements))
+	for _, e := range d.elements {
+		if !e.Deleted {
+			runes = append(runes, e.Value)
+		}
+	}
+	return string(runes)
+}
+
+func (d *CRDTDocument) setPath(path string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.Path = path
+}
+
+func (d *CRDTDocument) lock()   { d.mutex.Lock() }
+func (d *CRDTDocument) unlock() { d.mutex.Unlock() }
+
+// state also carries the whole op log, clients need the element IDs to
+// edit. The state vector covers ops compacted out of the log.
+func (d *CRDTDocument) state() DocumentState {
+	return DocumentState{
+		File:        d.Path,
+		Content:     d.text(),
+		Revision:    d.revision(),
+		Ops:         d.log,
+		StateVector: d.stateVector,
+		Compactions: d.compactions,
+	}
+}
+
+// restore loads the engine state of a snapshot
+func (d *CRDTDocument) restore(raw []byte) error {
+	var state crdtState
+	if raw = bytes.TrimSpace(raw); len(raw) > 0 && raw[0] == '[' {
+		if err := json.Unmarshal(raw, &state.Ops); err != nil {
+			return err
+		}
+	} else if err := json.Unmarshal(raw, &state); err != nil {
+		return err
+	}
+	for _, op := range state.Ops {
+		d.integrate(op)
+	}
+	// Sites whose ops were all compacted away are only in the vectors
+	for site, clock := range state.StateVector {
+		d.stateVector[site] = max(d.stateVector[site], clock)
+		d.clock = max(d.clock, clock)
+	}
+	for site, clock := range state.Compacted {
+		d.compacted[site] = clock
+	}
+	d.compactions = state.Compactions
+	return nil
+}
+
+// compact drops the tombstones and rewrites the op log as the inserts of
+// the remaining text, so new clients do not download every edit ever
+// made. It returns the dropped elements, clients still holding them are
+// told with "crdt-compact". Ops parked for a missing element may be
+// waiting for a tombstone, so nothing is dropped while there are any.
+// Inserts skip over tombstones, so the connected clients must hold every
+// op first, see stable. The caller must hold d.mutex.
+func (d *CRDTDocument) compact() []ElementID {
+	if len(d.pending) > 0 {
+		return nil
+	}
+	var removed []ElementID
+	elements := make([]*crdtElement, 0, len(d.elements))
+	for _, e := range d.elements {
+		if e.Deleted {
+			removed = append(removed, e.ID)
+			delete(d.byID, e.ID)
+			continue
+		}
+		elements = append(elements, e)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	revision := d.revision()
+	d.elements = elements
+	d.log = make([]CRDTOp, 0, len(elements))
+	var origin *ElementID
+	for _, e := range elements {
+		id := e.ID
+		d.log = append(d.log, CRDTOp{ID: id, Kind: crdtInsertKind, Origin: origin, Value: string(e.Value)})
+		origin = &id
+	}
+	d.base = revision - len(d.log)
+	for site, clock := range d.stateVector {
+		d.compacted[site] = clock
+	}
+	d.compactions++
+	return removed
+}
+
+// stable reports whether every connected client has acknowledged every
+// integrated op and holds none the server has not integrated, so that
+// all replicas drop the same tombstones and put later inserts in the same
+// place. Clients that were not connected have to resync after a
+// compaction. Acknowledgements of clients that left are forgotten. The
+// caller must hold d.mutex.
+func (d *CRDTDocument) stable(connections []*Connection) bool {
+	connected := make(map[*Connection]bool, len(connections))
+	for _, c := range connections {
+		connected[c] = true
+	}
+	for c := range d.acked {
+		if !connected[c] {
+			delete(d.acked, c)
+		}
+	}
+	if len(d.pending) > 0 {
+		return false
+	}
+
+	for _, c := range connections {
+		acked, exists := d.acked[c]
+		if !exists {
+			return false
+		}
+		for site, clock := range d.stateVector {
+			if acked[site] != clock {
+				return false
+			}
+		}
+		for site, clock := range acked {
+			if clock != d.stateVector[site] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Compact compacts the document once it is stable and queues the message
+// telling the room which tombstones went; the caller flushes it.
+func (d *CRDTDocument) Compact(h *Hub) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.expireStuck(h)
+	if !d.stable(h.connections(d.RoomID)) {
+		return
+	}
+	removed := d.compact()
+	if len(removed) == 0 {
+		return
+	}
+	h.enqueue(roomMessage(d.RoomID, 0, "crdt-compact", CRDTCompactData{
+		File:        d.Path,
+		Removed:     removed,
+		Revision:    d.revision(),
+		Compactions: d.compactions,
+	}))
+}
+
+// expire drops the ops that waited for their dependencies longer than
+// crdtPendingTimeout, along with the other parked ops of their sites, and
+// returns those sites. Their ops are refused until they resync. The
+// caller must hold d.mutex.
+func (d *CRDTDocument) expire(now time.Time) []string {
+	stuck := make(map[string]bool)
+	for _, p := range d.pending {
+		if now.Sub(p.parked) > crdtPendingTimeout {
+			stuck[p.op.ID.Site] = true
+		}
+	}
+	if len(stuck) == 0 {
+		return nil
+	}
+
+	kept := d.pending[:0]
+	for _, p := range d.pending {
+		if !stuck[p.op.ID.Site] {
+			kept = append(kept, p)
+		}
+	}
+	d.pending = kept
+	for site := range stuck {
+		d.expired[site] = true
+	}
+	return slices.Sorted(maps.Keys(stuck))
+}
+
+// expireStuck expires stuck ops and queues an error telling their users
+// to resync. The caller must hold d.mutex and flush.
+func (d *CRDTDocument) expireStuck(h *Hub) {
+	for _, site := range d.expire(time.Now()) {
+		user, _, _ := strings.Cut(site, ":")
+		userID, err := strconv.ParseInt(user, 10, 64)
+		if err != nil {
+			continue
+		}
+		h.enqueue(errorMessage(d.RoomID, userID, "crdt-update", ErrCRDTExpired.Error()))
+	}
+}
+
+// resynced lets the sites of a user that resynced send ops again. The
+// caller must hold d.mutex.
+func (d *CRDTDocument) resynced(userSite string) {
+	for site := range d.expired {
+		if ownsSite(userSite, site) {
+			delete(d.expired, site)
+		}
+	}
+}
+
+// needsReset reports whether a client has to rebuild its replica from the
+// whole op log, as it missed a compaction
+func (d *CRDTDocument) needsReset(sync CRDTSyncData) bool {
+	return d.behind(sync.StateVector) || sync.Compactions < d.compactions
+}
+
+// dropped reports whether id may have been removed by a compaction
+func (d *CRDTDocument) dropped(id ElementID) bool {
+	_, exists := d.byID[id]
+	return !exists && id.Clock <= d.compacted[id.Site]
+}
+
+// behind reports whether a state vector misses ops from before the last
+// compaction, which the compacted log can no longer replay
+func (d *CRDTDocument) behind(stateVector map[string]int) bool {
+	for site, clock := range d.compacted {
+		if stateVector[site] < clock {
+			return true
+		}
+	}
+	return false
+}
+
+// seen reports whether op was integrated already. Ops of a site arrive in
+// clock order, so the state vector is enough to tell.
+func (d *CRDTDocument) seen(op CRDTOp) bool {
+	return op.ID.Clock <= d.stateVector[op.ID.Site]
+}
+
+// ready reports whether everything op references has been integrated
+func (d *CRDTDocument) ready(op CRDTOp) bool {
+	switch op.Kind {
+	case crdtInsertKind:
+		if op.Origin == nil {
+			return true
+		}
+		_, exists := d.byID[*op.Origin]
+		return exists
+	case crdtDeleteKind:
+		_, exists := d.byID[*op.Target]
+		return exists
+	}
+	return false
+}
+
+func validCRDTOp(op CRDTOp) bool {
+	if op.ID.Site == "" || op.ID.Clock <= 0 {
+		return false
+	}
+	switch op.Kind {
+	case crdtInsertKind:
+		return utf8.RuneCountInString(op.Value) == 1
+	case crdtDeleteKind:
+		return op.Target != nil
+	}
+	return false
+}
+
+// integrate applies a ready op and returns the equivalent edit on the
+// visible text. The caller must hold d.mutex.
+func (d *CRDTDocument) integrate(op CRDTOp) *TextOperation {
+	var edit *TextOperation
+
+	switch op.Kind {
+	case crdtInsertKind:
+		// RGA: skip over elements right of the origin that carry a larger
+		// ID, they were inserted concurrently and win the tie.
+		index := 0
+		if op.Origin != nil {
+			index = d.indexOf(*op.Origin) + 1
+		}
+		for index < len(d.elements) && op.ID.less(d.elements[index].ID) {
+			index++
+		}
+		value, _ := utf8.DecodeRuneInString(op.Value)
+		element := &crdtElement{ID: op.ID, Value: value}
+		d.elements = append(d.elements, nil)
+		copy(d.elements[index+1:], d.elements[index:])
+		d.elements[index] = element
+		d.byID[op.ID] = element
+		edit = &TextOperation{Position: d.visibleBefore(index), Insert: op.Value}
+
+	case crdtDeleteKind:
+		target := d.byID[*op.Target]
+		if !target.Deleted {
+			target.Deleted = true
+			edit = &TextOperation{Position: d.visibleBefore(d.indexOf(target.ID)), Delete: 1}
+		}
+	}
+
+	d.stateVector[op.ID.Site] = op.ID.Clock
+	d.clock = max(d.clock, op.ID.Clock)
+	d.log = append(d.log, op)
+	return edit
+}
+
+// replaceText makes the server rewrite the whole document to text and
+// returns the ops it integrated and their edits. The caller must hold
+// d.mutex.
+func (d *CRDTDocument) replaceText(text string) ([]CRDTOp, []AppliedEdit) {
+	var ops []CRDTOp
+	next := func() ElementID {
+		d.clock++
+		return ElementID{Clock: d.clock, Site: crdtServerSite}
+	}
+	for _, e := range d.elements {
+		if !e.Deleted {
+			target := e.ID
+			ops = append(ops, CRDTOp{ID: next(), Kind: crdtDeleteKind, Target: &target})
+		}
+	}
+	var origin *ElementID
+	for _, r := range text {
+		id := next()
+		ops = append(ops, CRDTOp{ID: id, Kind: crdtInsertKind, Origin: origin, Value: string(r)})
+		origin = &id
+	}
+
+	// Server ops only reference elements that exist, so they are all ready
+	var edits []AppliedEdit
+	for _, op := range ops {
+		if edit := d.integrate(op); edit != nil {
+			edits = append(edits, AppliedEdit{Revision: d.revision(), Op: *edit})
+		}
+	}
+	return ops, edits
+}
+
+func (d *CRDTDocument) Replace(h *Hub, userID int64, text string) int {
+	d.mutex.Lock()
+	ops, edits := d.replaceText(text)
+	d.record(h, userID, edits)
+	revision := d.revision()
+	h.enqueue(roomMessage(d.RoomID, userID, "crdt-update", CRDTUpdateData{File: d.Path, Ops: ops, Revision: revision}))
+	d.mutex.Unlock()
+
+	h.flush()
+	return revision
+}
+
+// record queues the edits for the revision log. Only the last edit of a
+// batch may carry a checkpoint, the text is already past the others. The
+// caller must hold d.mutex.
+func (d *CRDTDocument) record(h *Hub, userID int64, edits []AppliedEdit) {
+	for i, edit := range edits {
+		checkpoints := &d.checkpoints
+		if i < len(edits)-1 {
+			checkpoints = nil
+		}
+		h.recordEdit(d.RoomID, d.Path, userID, edit.Revision, edit.Op, checkpoints, d.text)
+	}
+}
+
+func (d *CRDTDocument) indexOf(id ElementID) int {
+	for i, e := range d.elements {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *CRDTDocument) visibleBefore(index int) int {
+	count := 0
+	for _, e := range d.elements[:index] {
+		if !e.Deleted {
+			count++
+		}
+	}
+	return count
+}
+
+// apply integrates ops sent by site, parking the ones whose dependencies
+// are missing, and returns the ops that were integrated in order along
+// with the edits they made to the text. The caller must hold d.mutex.
+func (d *CRDTDocument) apply(site string, ops []CRDTOp) ([]CRDTOp, []AppliedEdit, error) {
+	for _, op := range ops {
+		if !validCRDTOp(op) {
+			return nil, nil, ErrBadCRDTOp
+		}
+		if !ownsSite(site, op.ID.Site) {
+			return nil, nil, ErrForeignSite
+		}
+		if d.seen(op) {
+			continue
+		}
+		if d.expired[op.ID.Site] {
+			return nil, nil, ErrCRDTExpired
+		}
+		if op.Origin != nil && d.dropped(*op.Origin) || op.Target != nil && d.dropped(*op.Target) {
+			return nil, nil, ErrCRDTCompacted
+		}
+	}
+	if len(d.pending)+len(ops) > maxPendingOps {
+		return nil, nil, ErrTooManyOps
+	}
+
+	queue := d.pending
+	now := time.Now()
+	for _, op := range ops {
+		queue = append(queue, pendingCRDTOp{op: op, parked: now})
+	}
+	d.pending = nil
+	var integrated []CRDTOp
+	var edits []AppliedEdit
+
+	for progress := true; progress; {
+		progress = false
+		var waiting []pendingCRDTOp
+		// A site's ops are integrated in clock order, so once one of them
+		// waits the later ones wait too.
+		blocked := make(map[string]bool)
+		for _, p := range queue {
+			op := p.op
+			switch {
+			case d.seen(op):
+			case !blocked[op.ID.Site] && d.ready(op):
+				if edit := d.integrate(op); edit != nil {
+					edits = append(edits, AppliedEdit{Revision: d.revision(), Op: *edit})
+				}
+				integrated = append(integrated, op)
+				progress = true
+			default:
+				blocked[op.ID.Site] = true
+				waiting = append(waiting, p)
+			}
+		}
+		queue = waiting
+	}
+	d.pending = queue
+
+	return integrated, edits, nil
+}
+
+// missing returns the ops not covered by a client's state vector.
+// The caller must hold d.mutex.
+func (d *CRDTDocument) missing(stateVector map[string]int) []CRDTOp {
+	var ops []CRDTOp
+	for _, op := range d.log {
+		if op.ID.Clock > stateVector[op.ID.Site] {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// HandleMessage processes "crdt-update" and "crdt-sync" messages.
+// Updates are integrated and the newly applied ops are relayed to the rest
+// of the room; syncs are answered with the ops the client has not seen.
+func (d *CRDTDocument) HandleMessage(h *Hub, c *Connection, msg WSMessage) {
+	switch msg.Type {
+	case "crdt-update":
+		var update CRDTUpdateData
+		if err := decodeData(msg.Data, &update); err != nil {
+			h.sendError(c, msg.Type, "malformed crdt payload")
+			return
+		}
+
+		d.mutex.Lock()
+		if update.StateVector != nil {
+			d.acked[c] = maps.Clone(update.StateVector)
+		}
+		d.expireStuck(h)
+		integrated, edits, err := d.apply(crdtSite(c.UserID), update.Ops)
+		if err != nil {
+			d.mutex.Unlock()
+			h.flush()
+			h.sendError(c, msg.Type, err.Error())
+			return
+		}
+		d.record(h, c.UserID, edits)
+		if len(integrated) > 0 {
+			msg.Data = CRDTUpdateData{File: d.Path, Ops: integrated, Revision: d.revision()}
+			broadcastMsg, _ := json.Marshal(msg)
+			h.enqueue(BroadcastMessage{
+				RoomID:  c.RoomID,
+				Message: broadcastMsg,
+				Sender:  c.UserID,
+			})
+		}
+		d.mutex.Unlock()
+
+		h.flush()
+
+	case "crdt-sync":
+		var sync CRDTSyncData
+		if err := decodeData(msg.Data, &sync); err != nil {
+			h.sendError(c, msg.Type, "malformed crdt payload")
+			return
+		}
+
+		d.mutex.Lock()
+		d.acked[c] = maps.Clone(sync.StateVector)
+		d.resynced(crdtSite(c.UserID))
+		data := CRDTSyncData{
+			File:        d.Path,
+			StateVector: maps.Clone(d.stateVector),
+			Compactions: d.compactions,
+			Revision:    d.revision(),
+		}
+		if d.needsReset(sync) {
+			data.Ops, data.Reset = d.log, true
+		} else {
+			data.Ops = d.missing(sync.StateVector)
+		}
+		response := WSMessage{
+			Type:      "crdt-sync",
+			RoomID:    c.RoomID,
+			UserID:    c.UserID,
+			Timestamp: time.Now().Unix(),
+			Data:      data,
+		}
+		responseMsg, _ := json.Marshal(response)
+		// Queued under the lock so that no update overtakes it
+		h.enqueue(DirectMessage{
+			RoomID:  c.RoomID,
+			UserID:  c.UserID,
+			Message: responseMsg,
+		})
+		d.mutex.Unlock()
+
+		h.flush()
+
+	default:
+		h.sendError(c, msg.Type, "room uses the crdt sync engine")
+	}
+}
@@ -0,0 +1,211 @@
+package sockets
+
+import (
+	"testing"
+	"time"
+)
+
+func crdtInsert(site string, clock int, origin *ElementID, value string) CRDTOp {
+	return CRDTOp{ID: ElementID{Clock: clock, Site: site}, Kind: crdtInsertKind, Origin: origin, Value: value}
+}
+
+func TestCRDTIntegrateOrder(t *testing.T) {
+	a := ElementID{Clock: 1, Site: "1"}
+	x := ElementID{Clock: 2, Site: "2"}
+	base := crdtInsert("1", 1, nil, "a")
+
+	// Each case is a set of batches from different sites; every delivery
+	// order must end with the same text.
+	type batch struct {
+		site string
+		ops  []CRDTOp
+	}
+	tests := []struct {
+		name    string
+		batches []batch
+		want    string
+	}{
+		{
+			name: "concurrent inserts at the same origin",
+			batches: []batch{
+				{"1", []CRDTOp{base}},
+				{"2", []CRDTOp{crdtInsert("2", 2, &a, "x")}},
+				{"3", []CRDTOp{crdtInsert("3", 2, &a, "y")}},
+			},
+			want: "ayx",
+		},
+		{
+			name: "concurrent inserts at the start",
+			batches: []batch{
+				{"1", []CRDTOp{crdtInsert("1", 1, nil, "a")}},
+				{"2", []CRDTOp{crdtInsert("2", 1, nil, "b")}},
+			},
+			want: "ba",
+		},
+		{
+			name: "insert after a concurrently deleted element",
+			batches: []batch{
+				{"1", []CRDTOp{base}},
+				{"2", []CRDTOp{crdtInsert("2", 2, &a, "x")}},
+				{"3", []CRDTOp{{ID: ElementID{Clock: 2, Site: "3"}, Kind: crdtDeleteKind, Target: &a}}},
+			},
+			want: "x",
+		},
+		{
+			name: "runs typed after the same origin",
+			batches: []batch{
+				{"1", []CRDTOp{base}},
+				{"2", []CRDTOp{crdtInsert("2", 2, &a, "x"), crdtInsert("2", 3, &x, "z")}},
+				{"3", []CRDTOp{crdtInsert("3", 2, &a, "y")}},
+			},
+			want: "ayxz",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, order := range permutations(len(tt.batches)) {
+				d := NewCRDTDocument(1, "main")
+				for _, i := range order {
+					if _, _, err := d.apply(tt.batches[i].site, tt.batches[i].ops); err != nil {
+						t.Fatalf("order %v: %v", order, err)
+					}
+				}
+				if len(d.pending) != 0 {
+					t.Errorf("order %v: %d ops still pending", order, len(d.pending))
+				}
+				if got := d.text(); got != tt.want {
+					t.Errorf("order %v: text = %q, want %q", order, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCRDTApplyRejectsForeignSite(t *testing.T) {
+	tests := []struct {
+		site string
+		op   CRDTOp
+		err  error
+	}{
+		{"1", crdtInsert("1", 1, nil, "a"), nil},
+		{"1", crdtInsert("1:tab-2", 1, nil, "a"), nil},
+		{"1", crdtInsert("2", 1, nil, "a"), ErrForeignSite},
+		{"1", crdtInsert("10", 1, nil, "a"), ErrForeignSite},
+		{"1", crdtInsert("1", 1, nil, "ab"), ErrBadCRDTOp},
+	}
+
+	for _, tt := range tests {
+		d := NewCRDTDocument(1, "main")
+		if _, _, err := d.apply(tt.site, []CRDTOp{tt.op}); err != tt.err {
+			t.Errorf("site %q op %v: err = %v, want %v", tt.site, tt.op.ID, err, tt.err)
+		}
+	}
+}
+
+func TestCRDTStable(t *testing.T) {
+	a := ElementID{Clock: 1, Site: "1"}
+	c1, c2, left := &Connection{UserID: 1}, &Connection{UserID: 2}, &Connection{UserID: 3}
+	parked := crdtInsert("2", 5, &ElementID{Clock: 4, Site: "3"}, "y")
+
+	tests := []struct {
+		name    string
+		acked   map[*Connection]map[string]int
+		pending []CRDTOp
+		stable  bool
+	}{
+		{"nobody connected acknowledged", nil, nil, false},
+		{"one client behind", map[*Connection]map[string]int{c1: {"1": 1, "2": 2}, c2: {"1": 1}}, nil, false},
+		{"everything acknowledged", map[*Connection]map[string]int{c1: {"1": 1, "2": 2}, c2: {"1": 1, "2": 2}}, nil, true},
+		{"ops in flight", map[*Connection]map[string]int{c1: {"1": 2, "2": 2}, c2: {"1": 1, "2": 2}}, nil, false},
+		{"ops pending", map[*Connection]map[string]int{c1: {"1": 1, "2": 2}, c2: {"1": 1, "2": 2}}, []CRDTOp{parked}, false},
+		{"client that left", map[*Connection]map[string]int{c1: {"1": 1, "2": 2}, c2: {"1": 1, "2": 2}, left: {}}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewCRDTDocument(1, "main")
+			d.apply("1", []CRDTOp{crdtInsert("1", 1, nil, "a")})
+			d.apply("2", []CRDTOp{{ID: ElementID{Clock: 2, Site: "2"}, Kind: crdtDeleteKind, Target: &a}})
+			d.apply("2", tt.pending)
+			for c, acked := range tt.acked {
+				d.acked[c] = acked
+			}
+
+			if stable := d.stable([]*Connection{c1, c2}); stable != tt.stable {
+				t.Errorf("stable = %v, want %v", stable, tt.stable)
+			}
+			if _, exists := d.acked[left]; exists {
+				t.Error("kept the acknowledgement of a client that left")
+			}
+		})
+	}
+}
+
+func TestCRDTExpire(t *testing.T) {
+	missing := ElementID{Clock: 1, Site: "3"}
+	d := NewCRDTDocument(1, "main")
+	d.apply("2", []CRDTOp{crdtInsert("2", 2, &missing, "x"), crdtInsert("2", 3, nil, "y")})
+	d.apply("1", []CRDTOp{crdtInsert("1", 1, &missing, "a")})
+	if len(d.pending) != 3 {
+		t.Fatalf("%d ops pending, want 3", len(d.pending))
+	}
+
+	if sites := d.expire(time.Now()); len(sites) != 0 {
+		t.Errorf("expired %v before the timeout", sites)
+	}
+	// Only the first op of site 2 has waited too long, the second goes too
+	d.pending[0].parked = time.Now().Add(-time.Minute)
+	sites := d.expire(time.Now())
+	if len(sites) != 1 || sites[0] != "2" || len(d.pending) != 1 {
+		t.Fatalf("expired %v leaving %d ops pending, want [2] leaving 1", sites, len(d.pending))
+	}
+
+	// A later op of the site would be integrated over the dropped ones
+	if _, _, err := d.apply("2", []CRDTOp{crdtInsert("2", 4, nil, "z")}); err != ErrCRDTExpired {
+		t.Errorf("err = %v, want %v", err, ErrCRDTExpired)
+	}
+	d.resynced("2")
+	if _, _, err := d.apply("2", []CRDTOp{crdtInsert("2", 2, nil, "x")}); err != nil {
+		t.Errorf("after a resync: %v", err)
+	}
+}
+
+func TestCRDTNeedsReset(t *testing.T) {
+	d := NewCRDTDocument(1, "main")
+	d.apply("1", []CRDTOp{crdtInsert("1", 1, nil, "a")})
+	d.apply("1", []CRDTOp{{ID: ElementID{Clock: 2, Site: "1"}, Kind: crdtDeleteKind, Target: &ElementID{Clock: 1, Site: "1"}}})
+	if removed := d.compact(); len(removed) != 1 {
+		t.Fatalf("removed %v, want the tombstone", removed)
+	}
+
+	tests := []struct {
+		name  string
+		sync  CRDTSyncData
+		reset bool
+	}{
+		{"missed the compacted ops", CRDTSyncData{StateVector: map[string]int{"1": 1}, Compactions: 1}, true},
+		{"missed the compaction", CRDTSyncData{StateVector: map[string]int{"1": 2}}, true},
+		{"up to date", CRDTSyncData{StateVector: map[string]int{"1": 2}, Compactions: 1}, false},
+	}
+	for _, tt := range tests {
+		if reset := d.needsReset(tt.sync); reset != tt.reset {
+			t.Errorf("%s: reset = %v, want %v", tt.name, reset, tt.reset)
+		}
+	}
+}
+
+// permutations lists every ordering of 0..n-1
+func permutations(n int) [][]int {
+	if n == 0 {
+		return [][]int{{}}
+	}
+	var orders [][]int
+	for _, rest := range permutations(n - 1) {
+		for i := 0; i <= len(rest); i++ {
+			order := append(append(append([]int{}, rest[:i]...), n-1), rest[i:]...)
+			orders = append(orders, order)
+		}
+	}
+	return orders
+}
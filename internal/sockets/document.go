@@ -0,0 +1,383 @@
+package sockets
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+)
+
+// Number of applied operations kept in memory for transforming late edits.
+// Clients further behind than this must resync.
+const maxHistory = 1024
+
+var (
+	ErrStaleRevision = errors.New("revision is too old, resync required")
+	ErrBadRevision   = errors.New("revision is ahead of the document")
+	ErrBadPosition   = errors.New("position is outside the document")
+)
+
+// TextOperation replaces Delete runes at Position with Insert.
+// Positions are rune offsets into the document.
+type TextOperation struct {
+	Position int    `json:"position"`
+	Delete   int    `json:"delete"`
+	Insert   string `json:"insert"`
+}
+
+// historyEntry is an operation that was applied to the document,
+// together with the text it removed so it can be undone.
+type historyEntry struct {
+	Op      TextOperation
+	Removed string
+	UserID  int64
+}
+
+// Sync engines a room can use for its document
+const (
+	EngineOT   = "ot"
+	EngineCRDT = "crdt"
+)
+
+// Document is the canonical state of one file of a room, kept by one of
+// the sync engines.
+type Document interface {
+	// Engine reports which sync engine keeps the document
+	Engine() string
+	// Content returns the current text and revision of the document
+	Content() (string, int)
+	// HandleMessage applies a client message to the document and fans the
+	// result out through the hub
+	HandleMessage(h *Hub, c *Connection, msg WSMessage)
+	// Snapshot returns the persisted form of the document
+	Snapshot() *store.Document
+	// Replace rewrites the whole text as an edit by userID, broadcasts it
+	// to the room and returns the new revision
+	Replace(h *Hub, userID int64, text string) int
+	// setPath moves the document to another file path
+	setPath(path string)
+	// lock and unlock guard state, which describes the document for
+	// clients that are joining
+	lock()
+	unlock()
+	state() DocumentState
+}
+
+// IsEngine reports whether engine names a supported sync engine
+func IsEngine(engine string) bool {
+	return engine == EngineOT || engine == EngineCRDT
+}
+
+// NewDocument creates an empty document kept by the given sync engine.
+// Unknown engines fall back to OT.
+func NewDocument(roomID int64, path, engine string) Document {
+	if engine == EngineCRDT {
+		return NewCRDTDocument(roomID, path)
+	}
+	return NewOTDocument(roomID, path)
+}
+
+// OTDocument is the server-authoritative copy of a room's code buffer.
+// Clients send edits against the revision they last saw; the document
+// transforms them over everything applied since, so every client
+// converges on the same content.
+type OTDocument struct {
+	RoomID   int64
+	Path     string
+	content  []rune
+	revision int
+	history  []historyEntry // history[i] produced revision (revision - len(history) + i + 1)
+	// Checkpoints of the revision log
+	checkpoints checkpointer
+	mutex       sync.Mutex
+}
+
+// AppliedEdit describes an edit after it was accepted by an OTDocument.
+// Change is expressed against the document as it was before the edit.
+type AppliedEdit struct {
+	Revision int
+	Op       TextOperation
+	Change   CodeChangeData
+}
+
+func NewOTDocument(roomID int64, path string) *OTDocument {
+	return &OTDocument{RoomID: roomID, Path: path}
+}
+
+func (d *OTDocument) Engine() string {
+	return EngineOT
+}
+
+func (d *OTDocument) Content() (string, int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return string(d.content), d.revision
+}
+
+func (d *OTDocument) Snapshot() *store.Document {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return &store.Document{
+		RoomId:   d.RoomID,
+		Path:     d.Path,
+		Content:  string(d.content),
+		Revision: d.revision,
+		Engine:   EngineOT,
+	}
+}
+
+func (d *OTDocument) setPath(path string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.Path = path
+}
+
+func (d *OTDocument) lock()   { d.mutex.Lock() }
+func (d *OTDocument) unlock() { d.mutex.Unlock() }
+
+func (d *OTDocument) state() DocumentState {
+	return DocumentState{
+		File:     d.Path,
+		Content:  string(d.content),
+		Revision: d.revision,
+	}
+}
+
+func (d *OTDocument) Replace(h *Hub, userID int64, text string) int {
+	d.mutex.Lock()
+	op := TextOperation{Position: 0, Delete: len(d.content), Insert: text}
+	change := CodeChangeData{
+		File:    d.Path,
+		Content: text,
+		From:    positionOf(d.content, 0),
+		To:      positionOf(d.content, len(d.content)),
+	}
+	d.commit(userID, op)
+	change.Revision = d.revision
+	h.recordEdit(d.RoomID, d.Path, userID, d.revision, op, &d.checkpoints, d.text)
+	h.enqueue(roomMessage(d.RoomID, userID, "editor", change))
+	revision := d.revision
+	d.mutex.Unlock()
+
+	h.flush()
+	return revision
+}
+
+func (d *OTDocument) text() string {
+	return string(d.content)
+}
+
+// apply transforms change over the operations applied after change.Revision
+// and applies it. The caller must hold d.mutex.
+func (d *OTDocument) apply(userID int64, change CodeChangeData) (*AppliedEdit, error) {
+	if change.Revision > d.revision || change.Revision < 0 {
+		return nil, ErrBadRevision
+	}
+	base := d.revision - len(d.history)
+	if change.Revision < base {
+		return nil, ErrStaleRevision
+	}
+
+	// Resolve the line/column range against the text the client saw
+	snapshot := d.content
+	for i := len(d.history) - 1; i >= change.Revision-base; i-- {
+		snapshot = undo(snapshot, d.history[i])
+	}
+	from, err := offsetOf(snapshot, change.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := offsetOf(snapshot, change.To)
+	if err != nil {
+		return nil, err
+	}
+	if to < from {
+		from, to = to, from
+	}
+
+	op := TextOperation{Position: from, Delete: to - from, Insert: change.Content}
+	for _, entry := range d.history[change.Revision-base:] {
+		op, _ = transform(op, entry.Op)
+	}
+
+	applied := &AppliedEdit{
+		Op: op,
+		Change: CodeChangeData{
+			File:    d.Path,
+			Content: op.Insert,
+			From:    positionOf(d.content, op.Position),
+			To:      positionOf(d.content, op.Position+op.Delete),
+		},
+	}
+	d.commit(userID, op)
+	applied.Revision = d.revision
+	applied.Change.Revision = d.revision
+
+	return applied, nil
+}
+
+// commit applies an already transformed operation and records it.
+// The caller must hold d.mutex.
+func (d *OTDocument) commit(userID int64, op TextOperation) {
+	removed := string(d.content[op.Position : op.Position+op.Delete])
+	d.content = replaceRunes(d.content, op.Position, op.Delete, op.Insert)
+	d.revision++
+
+	d.history = append(d.history, historyEntry{Op: op, Removed: removed, UserID: userID})
+	if len(d.history) > maxHistory {
+		d.history = d.history[len(d.history)-maxHistory:]
+	}
+}
+
+// transform rebases a over b, where both were made against the same
+// text and b has already been applied. It returns a' (a applied after b)
+// and b' (b applied after a) such that b·a' == a·b'. When the two edits
+// touch the same region, b's insertion goes first.
+func transform(a, b TextOperation) (TextOperation, TextOperation) {
+	aEnd := a.Position + a.Delete
+	bEnd := b.Position + b.Delete
+	aLen := runeLen(a.Insert)
+	bLen := runeLen(b.Insert)
+
+	switch {
+	case bEnd <= a.Position:
+		// b is entirely before a
+		a.Position += bLen - b.Delete
+		return a, b
+	case aEnd <= b.Position:
+		// a is entirely before b
+		b.Position += aLen - a.Delete
+		return a, b
+	}
+
+	// Overlapping edits: both collapse to replacing the union of the two
+	// ranges with b's text followed by a's text.
+	start := min(a.Position, b.Position)
+	end := max(aEnd, bEnd)
+	insert := b.Insert + a.Insert
+
+	aPrime := TextOperation{
+		Position: start,
+		Delete:   (end - start) - b.Delete + bLen,
+		Insert:   insert,
+	}
+	bPrime := TextOperation{
+		Position: start,
+		Delete:   (end - start) - a.Delete + aLen,
+		Insert:   insert,
+	}
+	return aPrime, bPrime
+}
+
+// undo reverts entry on text and returns the previous text.
+func undo(text []rune, entry historyEntry) []rune {
+	return replaceRunes(text, entry.Op.Position, runeLen(entry.Op.Insert), entry.Removed)
+}
+
+func replaceRunes(text []rune, pos, del int, insert string) []rune {
+	ins := []rune(insert)
+	out := make([]rune, 0, len(text)-del+len(ins))
+	out = append(out, text[:pos]...)
+	out = append(out, ins...)
+	out = append(out, text[pos+del:]...)
+	return out
+}
+
+func runeLen(s string) int {
+	return len([]rune(s))
+}
+
+// offsetOf converts a zero based line/column into a rune offset.
+func offsetOf(text []rune, pos CursorData) (int, error) {
+	if pos.Line < 0 || pos.Column < 0 {
+		return 0, ErrBadPosition
+	}
+	line, offset := 0, 0
+	for line < pos.Line {
+		if offset >= len(text) {
+			return 0, ErrBadPosition
+		}
+		if text[offset] == '\n' {
+			line++
+		}
+		offset++
+	}
+	for col := 0; col < pos.Column; col++ {
+		if offset >= len(text) || text[offset] == '\n' {
+			return 0, ErrBadPosition
+		}
+		offset++
+	}
+	return offset, nil
+}
+
+// positionOf converts a rune offset into a zero based line/column.
+func positionOf(text []rune, offset int) CursorData {
+	var pos CursorData
+	for _, r := range text[:offset] {
+		if r == '\n' {
+			pos.Line++
+			pos.Column = 0
+		} else {
+			pos.Column++
+		}
+	}
+	return pos
+}
+
+// HandleMessage applies an "editor" message to the document.
+// The sender gets an "editor-ack" with the new revision and everyone else
+// gets the transformed change. Clients keep at most one unacknowledged
+// edit in flight and rebase their pending edits over incoming changes.
+func (d *OTDocument) HandleMessage(h *Hub, c *Connection, msg WSMessage) {
+	if msg.Type != "editor" {
+		h.sendError(c, msg.Type, "room uses the ot sync engine")
+		return
+	}
+
+	var change CodeChangeData
+	if err := decodeData(msg.Data, &change); err != nil {
+		h.sendError(c, msg.Type, "malformed editor payload")
+		return
+	}
+
+	d.mutex.Lock()
+	applied, err := d.apply(c.UserID, change)
+	if err != nil {
+		d.mutex.Unlock()
+		h.sendError(c, msg.Type, err.Error())
+		return
+	}
+	h.recordEdit(d.RoomID, d.Path, c.UserID, applied.Revision, applied.Op, &d.checkpoints, d.text)
+
+	// Both messages are queued while holding the document lock so that
+	// every client sees revisions in order.
+	ack, _ := json.Marshal(WSMessage{
+		Type:      "editor-ack",
+		RoomID:    c.RoomID,
+		UserID:    c.UserID,
+		Timestamp: time.Now().Unix(),
+		Data:      map[string]any{"file": d.Path, "revision": applied.Revision},
+	})
+	h.enqueue(DirectMessage{
+		RoomID:  c.RoomID,
+		UserID:  c.UserID,
+		Message: ack,
+	})
+
+	msg.Data = applied.Change
+	broadcastMsg, _ := json.Marshal(msg)
+	h.enqueue(BroadcastMessage{
+		RoomID:  c.RoomID,
+		Message: broadcastMsg,
+		Sender:  c.UserID,
+	})
+	d.mutex.Unlock()
+
+	h.flush()
+}
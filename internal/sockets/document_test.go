@@ -0,0 +1,108 @@
+package sockets
+
+import "testing"
+
+func TestTransformConverges(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		a, b TextOperation
+	}{
+		{"a before b", "hello world", TextOperation{Position: 0, Insert: "oh "}, TextOperation{Position: 6, Delete: 5, Insert: "there"}},
+		{"b before a", "hello world", TextOperation{Position: 11, Insert: "!"}, TextOperation{Position: 0, Delete: 1, Insert: "J"}},
+		{"same position inserts", "abc", TextOperation{Position: 1, Insert: "x"}, TextOperation{Position: 1, Insert: "y"}},
+		{"overlapping deletes", "abcdef", TextOperation{Position: 1, Delete: 3}, TextOperation{Position: 2, Delete: 3}},
+		{"nested replace", "abcdef", TextOperation{Position: 0, Delete: 6, Insert: "new"}, TextOperation{Position: 2, Delete: 2, Insert: "CD"}},
+		{"adjacent edits", "abcdef", TextOperation{Position: 0, Delete: 3, Insert: "X"}, TextOperation{Position: 3, Delete: 3, Insert: "Y"}},
+		{"multibyte text", "héllo wörld", TextOperation{Position: 1, Delete: 1, Insert: "e"}, TextOperation{Position: 7, Delete: 1, Insert: "o"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := []rune(tt.text)
+			aPrime, bPrime := transform(tt.a, tt.b)
+
+			afterB := replaceRunes(text, tt.b.Position, tt.b.Delete, tt.b.Insert)
+			ba := replaceRunes(afterB, aPrime.Position, aPrime.Delete, aPrime.Insert)
+			afterA := replaceRunes(text, tt.a.Position, tt.a.Delete, tt.a.Insert)
+			ab := replaceRunes(afterA, bPrime.Position, bPrime.Delete, bPrime.Insert)
+
+			if string(ba) != string(ab) {
+				t.Errorf("b·a' = %q, a·b' = %q", string(ba), string(ab))
+			}
+		})
+	}
+}
+
+func TestOTDocumentApplyConcurrent(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		changes []CodeChangeData
+		want    string
+	}{
+		{
+			name: "edits on different lines",
+			text: "one\ntwo\n",
+			changes: []CodeChangeData{
+				{Revision: 1, Content: "1", From: CursorData{Line: 0}, To: CursorData{Line: 0, Column: 3}},
+				{Revision: 1, Content: "2", From: CursorData{Line: 1}, To: CursorData{Line: 1, Column: 3}},
+			},
+			want: "1\n2\n",
+		},
+		{
+			name: "inserts at the same position",
+			text: "ac",
+			changes: []CodeChangeData{
+				{Revision: 1, Content: "x", From: CursorData{Column: 1}, To: CursorData{Column: 1}},
+				{Revision: 1, Content: "y", From: CursorData{Column: 1}, To: CursorData{Column: 1}},
+			},
+			want: "axyc",
+		},
+		{
+			name: "delete over a concurrent insert",
+			text: "abcdef",
+			changes: []CodeChangeData{
+				{Revision: 1, Content: "X", From: CursorData{Column: 3}, To: CursorData{Column: 3}},
+				{Revision: 1, From: CursorData{Column: 1}, To: CursorData{Column: 5}},
+			},
+			want: "aXf",
+		},
+		{
+			name: "edit against the latest revision",
+			text: "abc",
+			changes: []CodeChangeData{
+				{Revision: 1, Content: "1", From: CursorData{}, To: CursorData{}},
+				{Revision: 2, Content: "2", From: CursorData{Column: 4}, To: CursorData{Column: 4}},
+			},
+			want: "1abc2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewOTDocument(1, "main")
+			d.commit(0, TextOperation{Insert: tt.text})
+			for i, change := range tt.changes {
+				if _, err := d.apply(int64(i+1), change); err != nil {
+					t.Fatalf("change %d: %v", i, err)
+				}
+			}
+			if got := d.text(); got != tt.want {
+				t.Errorf("text = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOTDocumentApplyRejectsBadRevision(t *testing.T) {
+	d := NewOTDocument(1, "main")
+	d.commit(0, TextOperation{Insert: "abc"})
+
+	for _, revision := range []int{-1, 2} {
+		_, err := d.apply(1, CodeChangeData{Revision: revision})
+		if err != ErrBadRevision {
+			t.Errorf("revision %d: err = %v, want %v", revision, err, ErrBadRevision)
+		}
+	}
+}
@@ -2,25 +2,139 @@ package sockets
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Alter-Sitanshu/CodeEditor/internal/env"
+	"github.com/Alter-Sitanshu/CodeEditor/internal/languages"
 )
 
 var API_KEY string = env.GetString("JUDGE0_KEY", "")
 
+// Judge0 auth schemes
+const (
+	Judge0AuthRapidAPI = "rapidapi" // x-rapidapi-key/x-rapidapi-host
+	Judge0AuthToken    = "token"    // X-Auth-Token of a self-hosted instance
+	Judge0AuthNone     = "none"
+)
+
+const DefaultJudge0URL = "https://judge0-ce.p.rapidapi.com"
+
+// How long a submission waits for its callback before polling once
+const judge0CallbackTimeout = 30 * time.Second
+
+// Callbacks that arrive before their submission is waited for are kept
+// this long
+const judge0EarlyCallbackTTL = time.Minute
+
+var (
+	ErrBadCallback        = errors.New("callback secret does not match")
+	ErrBadCallbackPayload = errors.New("callback output is not base64")
+)
+
+// Executor runs code submitted from a room
+type Executor interface {
+	// ExecuteCode runs req and reports how it went. Failures of the code
+	// itself are described in the response; the error is for failures of
+	// the executor.
+	ExecuteCode(ctx context.Context, req ExecuteRequest) (*ExecuteResponse, error)
+}
+
+// OutputFunc receives output of a running program as it is produced.
+// stream is "stdout" or "stderr".
+type OutputFunc func(stream, data string)
+
+// StreamingExecutor is an Executor that can pass output on while the code
+// runs
+type StreamingExecutor interface {
+	Executor
+	ExecuteStream(ctx context.Context, req ExecuteRequest, output OutputFunc) (*ExecuteResponse, error)
+}
+
+// InteractiveExecutor is an Executor that can run programs reading stdin
+// while they run
+type InteractiveExecutor interface {
+	Executor
+	ExecuteInteractive(ctx context.Context, req ExecuteRequest, stdin io.Reader,
+		output OutputFunc) (*ExecuteResponse, error)
+}
+
+// BatchCase is one input a BatchExecutor runs code against
+type BatchCase struct {
+	Input       string
+	TimeLimit   time.Duration
+	MemoryLimit int // KB
+}
+
+// request is req run against the case
+func (c BatchCase) request(req ExecuteRequest) ExecuteRequest {
+	req.Input = c.Input
+	req.TimeLimit = c.TimeLimit
+	req.MemoryLimit = c.MemoryLimit
+	return req
+}
+
+// BatchExecutor is an Executor that can build code once and run it
+// against many inputs. done gets the response of every case in order as
+// soon as it is known; if the code does not build, every case gets the
+// build's response.
+type BatchExecutor interface {
+	Executor
+	ExecuteBatch(ctx context.Context, req ExecuteRequest, cases []BatchCase,
+		done func(i int, response *ExecuteResponse)) error
+}
+
+// Judge0Config points a Judge0Executor at an instance. Zero values take
+// the public RapidAPI instance's settings.
+type Judge0Config struct {
+	BaseURL    string
+	AuthScheme string
+	AuthToken  string
+	Languages  map[string]int
+	// When set, Judge0 pushes results to this URL instead of being
+	// polled. Secret is added to it so the callback can be trusted.
+	CallbackURL    string
+	CallbackSecret string
+}
+
 type Judge0Executor struct {
 	baseURL string
 	client  *http.Client
+	config  Judge0Config
+	// Submissions waiting for their callback, by token
+	waiting map[string]chan Judge0Response
+	early   map[string]earlyCallback
+	mutex   sync.Mutex
+}
+
+type earlyCallback struct {
+	response Judge0Response
+	received time.Time
 }
 
 type ExecuteRequest struct {
 	Code     string `json:"code" validate:"required"`
-	Language string `json:"language" validate:"required,oneof=go python javascript java"`
+	Language string `json:"language" validate:"required"`
 	Input    string `json:"input"`
+	// Take stdin from "execution-input" messages of the room while the
+	// program runs, instead of Input
+	Interactive bool `json:"interactive"`
+	// Run the code even if the result of the same run is cached
+	NoCache bool `json:"no_cache"`
+	// Limits of the run when judging, zero for the executor's own
+	TimeLimit   time.Duration `json:"-"`
+	MemoryLimit int           `json:"-"` // KB
 }
 
 type ExecuteResponse struct {
@@ -28,13 +142,19 @@ type ExecuteResponse struct {
 	Error    string `json:"error,omitempty"`
 	ExitCode int    `json:"exit_code"`
 	Runtime  string `json:"runtime"`
+	Memory   int    `json:"memory,omitempty"` // peak memory in KB
 	Status   string `json:"status"`
+	// "hit" or "miss" when the result cache was consulted
+	Cache string `json:"cache,omitempty"`
 }
 
 type Judge0Request struct {
-	SourceCode string `json:"source_code"`
-	LanguageID int    `json:"language_id"`
-	Stdin      string `json:"stdin,omitempty"`
+	SourceCode  string  `json:"source_code"`
+	LanguageID  int     `json:"language_id"`
+	Stdin       string  `json:"stdin,omitempty"`
+	CallbackURL string  `json:"callback_url,omitempty"`
+	CPUTimeout  float64 `json:"cpu_time_limit,omitempty"`
+	MemoryLimit int     `json:"memory_limit,omitempty"`
 }
 
 type Judge0Response struct {
@@ -43,19 +163,101 @@ type Judge0Response struct {
 		ID          int    `json:"id"`
 		Description string `json:"description"`
 	} `json:"status"`
-	Stdout string `json:"stdout"`
-	Stderr string `json:"stderr"`
-	Time   string `json:"time"`
+	Stdout        string `json:"stdout"`
+	Stderr        string `json:"stderr"`
+	CompileOutput string `json:"compile_output"`
+	Message       string `json:"message"`
+	Time          string `json:"time"`
+	Memory        int    `json:"memory"`
+}
+
+// Judge0 wraps base64 at 60 characters
+var base64Breaks = strings.NewReplacer("\n", "", "\r", "")
+
+// decode decodes the output fields, which Judge0 sends base64 encoded in
+// callbacks and when asked with base64_encoded=true
+func (r *Judge0Response) decode() error {
+	for _, field := range []*string{&r.Stdout, &r.Stderr, &r.CompileOutput, &r.Message} {
+		decoded, err := base64.StdEncoding.DecodeString(base64Breaks.Replace(*field))
+		if err != nil {
+			return err
+		}
+		*field = string(decoded)
+	}
+	return nil
 }
 
-func NewJudge0Executor() *Judge0Executor {
+func NewJudge0Executor(cfg Judge0Config) *Judge0Executor {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultJudge0URL
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	if cfg.AuthScheme == "" {
+		cfg.AuthScheme = Judge0AuthRapidAPI
+	}
+	if cfg.AuthScheme == Judge0AuthRapidAPI && cfg.AuthToken == "" {
+		cfg.AuthToken = API_KEY
+	}
+	if cfg.Languages == nil {
+		cfg.Languages = languages.Default().Judge0IDs()
+	}
+
 	return &Judge0Executor{
-		baseURL: "https://judge0-ce.p.rapidapi.com",
+		baseURL: cfg.BaseURL,
 		client:  &http.Client{Timeout: 30 * time.Second},
+		config:  cfg,
+		waiting: make(map[string]chan Judge0Response),
+		early:   make(map[string]earlyCallback),
+	}
+}
+
+// ParseJudge0Languages reads a language map written as
+// "go=95,python=92,..."
+func ParseJudge0Languages(s string) (map[string]int, error) {
+	languages := make(map[string]int)
+	for _, pair := range strings.Split(s, ",") {
+		name, id, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("language %q is not name=id", pair)
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("language id of %q is not valid", name)
+		}
+		languages[strings.TrimSpace(name)] = n
 	}
+	return languages, nil
+}
+
+// Callbacks reports whether results are pushed by Judge0
+func (e *Judge0Executor) Callbacks() bool {
+	return e.config.CallbackURL != ""
 }
 
-func (e *Judge0Executor) ExecuteCode(req ExecuteRequest) (*ExecuteResponse, error) {
+// setHeaders authenticates a request to Judge0
+func (e *Judge0Executor) setHeaders(req *http.Request) {
+	req.Header.Set("Content-Type", "application/json")
+	switch e.config.AuthScheme {
+	case Judge0AuthRapidAPI:
+		req.Header.Set("x-rapidapi-host", req.URL.Host)
+		req.Header.Set("x-rapidapi-key", e.config.AuthToken)
+	case Judge0AuthToken:
+		req.Header.Set("X-Auth-Token", e.config.AuthToken)
+	}
+}
+
+func (e *Judge0Executor) callbackURL() string {
+	if e.config.CallbackSecret == "" {
+		return e.config.CallbackURL
+	}
+	sep := "?"
+	if strings.Contains(e.config.CallbackURL, "?") {
+		sep = "&"
+	}
+	return e.config.CallbackURL + sep + "secret=" + url.QueryEscape(e.config.CallbackSecret)
+}
+
+func (e *Judge0Executor) ExecuteCode(ctx context.Context, req ExecuteRequest) (*ExecuteResponse, error) {
 	langID := e.getLanguageID(req.Language)
 	if langID == 0 {
 		return &ExecuteResponse{
@@ -64,18 +266,22 @@ func (e *Judge0Executor) ExecuteCode(req ExecuteRequest) (*ExecuteResponse, erro
 		}, nil
 	}
 
+	// Encoded so code and output that are not UTF-8 make it through
 	judgeReq := Judge0Request{
-		SourceCode: req.Code,
-		LanguageID: langID,
-		Stdin:      req.Input,
+		SourceCode:  base64.StdEncoding.EncodeToString([]byte(req.Code)),
+		LanguageID:  langID,
+		Stdin:       base64.StdEncoding.EncodeToString([]byte(req.Input)),
+		CPUTimeout:  req.TimeLimit.Seconds(),
+		MemoryLimit: req.MemoryLimit,
+	}
+	if e.Callbacks() {
+		judgeReq.CallbackURL = e.callbackURL()
 	}
 
 	jsonData, _ := json.Marshal(judgeReq)
 
-	httpReq, _ := http.NewRequest("POST", e.baseURL+"/submissions", bytes.NewBuffer(jsonData))
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("x-rapidapi-host", "judge0-ce.p.rapidapi.com")
-	httpReq.Header.Set("x-rapidapi-key", API_KEY)
+	httpReq, _ := http.NewRequestWithContext(ctx, "POST", e.baseURL+"/submissions?base64_encoded=true", bytes.NewBuffer(jsonData))
+	e.setHeaders(httpReq)
 
 	resp, err := e.client.Do(httpReq)
 	if err != nil {
@@ -85,83 +291,148 @@ func (e *Judge0Executor) ExecuteCode(req ExecuteRequest) (*ExecuteResponse, erro
 		}, nil
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return &ExecuteResponse{
+			Error:  fmt.Sprintf("Submission rejected: %s", resp.Status),
+			Status: "error",
+		}, nil
+	}
 
 	var submitResp struct {
 		Token string `json:"token"`
 	}
 	json.NewDecoder(resp.Body).Decode(&submitResp)
 
-	return e.pollResult(submitResp.Token)
+	if e.Callbacks() {
+		return e.waitCallback(ctx, submitResp.Token)
+	}
+	return e.pollResult(ctx, submitResp.Token)
 }
 
 func (e *Judge0Executor) getLanguageID(language string) int {
-	// Judge0 language IDs
-	langMap := map[string]int{
-		"go":         95, // Go 1.18.5
-		"python":     92, // Python 3.10.0
-		"javascript": 93, // Node.js 18.15.0
-		"java":       91, // Java 17.0.6
+	return e.config.Languages[language]
+}
+
+// HandleCallback decodes a result pushed by Judge0 and hands it to the
+// submission waiting for it
+func (e *Judge0Executor) HandleCallback(secret string, result Judge0Response) error {
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(e.config.CallbackSecret)) != 1 {
+		return ErrBadCallback
+	}
+	if err := result.decode(); err != nil {
+		return ErrBadCallbackPayload
 	}
-	return langMap[language]
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if waiting, exists := e.waiting[result.Token]; exists {
+		delete(e.waiting, result.Token)
+		waiting <- result
+		return nil
+	}
+
+	// The submission request has not returned yet
+	now := time.Now()
+	for token, early := range e.early {
+		if now.Sub(early.received) > judge0EarlyCallbackTTL {
+			delete(e.early, token)
+		}
+	}
+	e.early[result.Token] = earlyCallback{response: result, received: now}
+	return nil
 }
 
-func (e *Judge0Executor) pollResult(token string) (*ExecuteResponse, error) {
-	maxAttempts := 10
-	pollInterval := 2 * time.Second
+// waitCallback waits for the result of a submission to be pushed. If it
+// does not come in time the submission is polled once.
+func (e *Judge0Executor) waitCallback(ctx context.Context, token string) (*ExecuteResponse, error) {
+	e.mutex.Lock()
+	if early, exists := e.early[token]; exists {
+		delete(e.early, token)
+		e.mutex.Unlock()
+		return judge0Result(early.response), nil
+	}
+	waiting := make(chan Judge0Response, 1)
+	e.waiting[token] = waiting
+	e.mutex.Unlock()
 
-	for attempt := 0; attempt < maxAttempts; attempt++ {
-		// Get submission result
-		url := fmt.Sprintf("%s/submissions/%s", e.baseURL, token)
-		req, _ := http.NewRequest("GET", url, nil)
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("x-rapidapi-host", "judge0-ce.p.rapidapi.com")
-		req.Header.Set("x-rapidapi-key", API_KEY)
+	defer func() {
+		e.mutex.Lock()
+		delete(e.waiting, token)
+		e.mutex.Unlock()
+	}()
 
-		resp, err := e.client.Do(req)
+	select {
+	case result := <-waiting:
+		return judge0Result(result), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(judge0CallbackTimeout):
+		result, err := e.fetchResult(ctx, token)
 		if err != nil {
 			return &ExecuteResponse{
-				Error:  fmt.Sprintf("Poll request failed: %v", err),
+				Error:  err.Error(),
 				Status: "error",
 			}, nil
 		}
-		defer resp.Body.Close()
-
-		var judgeResp Judge0Response
-		if err := json.NewDecoder(resp.Body).Decode(&judgeResp); err != nil {
+		if result.Status.ID <= 2 {
 			return &ExecuteResponse{
-				Error:  fmt.Sprintf("Failed to decode response: %v", err),
-				Status: "error",
+				Error:  "Execution timeout - result not ready",
+				Status: "timeout",
 			}, nil
 		}
+		return judge0Result(*result), nil
+	}
+}
 
-		// Checking if execution is complete
-		statusID := judgeResp.Status.ID
+// fetchResult gets the current state of a submission
+func (e *Judge0Executor) fetchResult(ctx context.Context, token string) (*Judge0Response, error) {
+	url := fmt.Sprintf("%s/submissions/%s?base64_encoded=true", e.baseURL, token)
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	e.setHeaders(req)
 
-		if statusID <= 2 {
-			// Still processing
-			time.Sleep(pollInterval)
-			continue
-		}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Poll request failed: %v", err)
+	}
+	defer resp.Body.Close()
 
-		// Execution completed, return result
-		response := &ExecuteResponse{
-			Output:  judgeResp.Stdout,
-			Runtime: judgeResp.Time,
+	var judgeResp Judge0Response
+	if err := json.NewDecoder(resp.Body).Decode(&judgeResp); err != nil {
+		return nil, fmt.Errorf("Failed to decode response: %v", err)
+	}
+	if err := judgeResp.decode(); err != nil {
+		return nil, fmt.Errorf("Failed to decode output: %v", err)
+	}
+	return &judgeResp, nil
+}
+
+func (e *Judge0Executor) pollResult(ctx context.Context, token string) (*ExecuteResponse, error) {
+	maxAttempts := 10
+	pollInterval := 2 * time.Second
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		// Get submission result
+		judgeResp, err := e.fetchResult(ctx, token)
+		if err != nil {
+			return &ExecuteResponse{
+				Error:  err.Error(),
+				Status: "error",
+			}, nil
 		}
 
-		if statusID == 3 {
-			response.Status = "success"
-			response.ExitCode = 0
-		} else {
-			response.Status = "error"
-			response.Error = judgeResp.Stderr
-			if response.Error == "" {
-				response.Error = judgeResp.Status.Description
+		// Checking if execution is complete
+		if judgeResp.Status.ID <= 2 {
+			// Still processing
+			select {
+			case <-time.After(pollInterval):
+			case <-ctx.Done():
+				return nil, ctx.Err()
 			}
-			response.ExitCode = 1
+			continue
 		}
 
-		return response, nil
+		return judge0Result(*judgeResp), nil
 	}
 
 	return &ExecuteResponse{
@@ -169,3 +440,38 @@ func (e *Judge0Executor) pollResult(token string) (*ExecuteResponse, error) {
 		Status: "timeout",
 	}, nil
 }
+
+// judge0Result converts a finished submission into a response
+func judge0Result(judgeResp Judge0Response) *ExecuteResponse {
+	response := &ExecuteResponse{
+		Output:  judgeResp.Stdout,
+		Runtime: judgeResp.Time,
+		Memory:  judgeResp.Memory,
+	}
+
+	switch judgeResp.Status.ID {
+	case 3:
+		response.Status = "success"
+		response.ExitCode = 0
+	case 5:
+		response.Status = "timeout"
+		response.Error = judgeResp.Status.Description
+		response.ExitCode = 1
+	case 6:
+		response.Status = "compile_error"
+		response.Error = judgeResp.CompileOutput
+		response.ExitCode = 1
+	default:
+		response.Status = "error"
+		response.Error = judgeResp.Stderr
+		if response.Error == "" {
+			response.Error = judgeResp.CompileOutput
+		}
+		if response.Error == "" {
+			response.Error = judgeResp.Status.Description
+		}
+		response.ExitCode = 1
+	}
+
+	return response
+}
@@ -0,0 +1,99 @@
+package sockets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJudge0Callback(t *testing.T) {
+	encode := base64.StdEncoding.EncodeToString
+
+	tests := []struct {
+		name   string
+		result Judge0Response
+		want   ExecuteResponse
+		err    error
+	}{
+		{
+			name:   "output",
+			result: judge0Response(3, encode([]byte("hello\n")), "", ""),
+			want:   ExecuteResponse{Output: "hello\n", Status: "success"},
+		},
+		{
+			name:   "output wrapped at 60 characters",
+			result: judge0Response(3, "YWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFh\nYWFhYQ==\n", "", ""),
+			want:   ExecuteResponse{Output: strings.Repeat("a", 49), Status: "success"},
+		},
+		{
+			name:   "compile error",
+			result: judge0Response(6, "", "", encode([]byte("main.go:1: syntax error"))),
+			want:   ExecuteResponse{Error: "main.go:1: syntax error", Status: "compile_error", ExitCode: 1},
+		},
+		{
+			name:   "runtime error",
+			result: judge0Response(11, "", encode([]byte("panic: boom")), ""),
+			want:   ExecuteResponse{Error: "panic: boom", Status: "error", ExitCode: 1},
+		},
+		{
+			name:   "not base64",
+			result: judge0Response(3, "hello!", "", ""),
+			err:    ErrBadCallbackPayload,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var submitted Judge0Request
+			judge0 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("base64_encoded") != "true" {
+					t.Errorf("submission is not base64 encoded: %s", r.URL)
+				}
+				json.NewDecoder(r.Body).Decode(&submitted)
+				w.WriteHeader(http.StatusCreated)
+				json.NewEncoder(w).Encode(map[string]string{"token": "token"})
+			}))
+			defer judge0.Close()
+
+			e := NewJudge0Executor(Judge0Config{
+				BaseURL:        judge0.URL,
+				AuthScheme:     Judge0AuthNone,
+				Languages:      map[string]int{"go": 95},
+				CallbackURL:    "http://editor/v1/judge0/callback",
+				CallbackSecret: "secret",
+			})
+			// Early callbacks are kept, so the callback can come first
+			tt.result.Token = "token"
+			if err := e.HandleCallback("secret", tt.result); err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+
+			resp, err := e.ExecuteCode(context.Background(), ExecuteRequest{Language: "go", Code: "package main", Input: "1 2"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if submitted.SourceCode != encode([]byte("package main")) || submitted.Stdin != encode([]byte("1 2")) {
+				t.Errorf("submitted %q and %q, want them base64 encoded", submitted.SourceCode, submitted.Stdin)
+			}
+			if *resp != tt.want {
+				t.Errorf("response = %+v, want %+v", *resp, tt.want)
+			}
+		})
+	}
+}
+
+func judge0Response(status int, stdout, stderr, compileOutput string) Judge0Response {
+	var r Judge0Response
+	r.Status.ID = status
+	r.Stdout = stdout
+	r.Stderr = stderr
+	r.CompileOutput = compileOutput
+	return r
+}
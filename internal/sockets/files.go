@@ -0,0 +1,125 @@
+package sockets
+
+import (
+	"strings"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+)
+
+// FileMove is the payload of "file-moved" messages
+type FileMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// HandleDocumentMessage routes an editor or CRDT message to the document
+// of the file it targets. Messages without a file edit the default file.
+func (h *Hub) HandleDocumentMessage(c *Connection, msg WSMessage) {
+	var target struct {
+		File string `json:"file"`
+	}
+	if err := decodeData(msg.Data, &target); err != nil {
+		h.sendError(c, msg.Type, "malformed payload")
+		return
+	}
+	if target.File == "" {
+		target.File = store.DefaultFile
+	}
+
+	doc, err := h.document(c.RoomID, target.File)
+	if err != nil {
+		h.sendError(c, msg.Type, err.Error())
+		return
+	}
+	doc.HandleMessage(h, c, msg)
+}
+
+// inside reports whether path is dir or lies under it
+func inside(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// FileCreated adds a new file or folder to a live room, along with any
+// parent folders it implies, and tells the room's users
+func (h *Hub) FileCreated(userID int64, engine string, file store.RoomFile) {
+	h.docMutex.Lock()
+	if room, exists := h.documents[file.RoomId]; exists && room.loading() {
+		// The room is loaded again in case the load missed this
+		room.stale = true
+	} else if exists {
+		for i, r := range file.Path {
+			if r != '/' {
+				continue
+			}
+			parent := file.Path[:i]
+			if _, exists := room.tree[parent]; !exists {
+				room.tree[parent] = store.RoomFile{
+					RoomId:    file.RoomId,
+					Path:      parent,
+					IsDir:     true,
+					CreatedAt: file.CreatedAt,
+				}
+			}
+		}
+		room.tree[file.Path] = file
+		if !file.IsDir {
+			room.files[file.Path] = &roomDocument{Document: NewDocument(file.RoomId, file.Path, engine)}
+		}
+	}
+	h.docMutex.Unlock()
+
+	h.broadcastAll(file.RoomId, userID, "file-created", file)
+}
+
+// FileMoved renames a file or folder of a live room and tells its users
+func (h *Hub) FileMoved(roomID, userID int64, from, to string) {
+	h.docMutex.Lock()
+	if room, exists := h.documents[roomID]; exists && room.loading() {
+		// The room is loaded again in case the load missed this
+		room.stale = true
+	} else if exists {
+		for path, file := range room.tree {
+			if inside(path, from) {
+				delete(room.tree, path)
+				file.Path = to + path[len(from):]
+				room.tree[file.Path] = file
+			}
+		}
+		for path, doc := range room.files {
+			if inside(path, from) {
+				delete(room.files, path)
+				newPath := to + path[len(from):]
+				doc.setPath(newPath)
+				room.files[newPath] = doc
+			}
+		}
+	}
+	h.docMutex.Unlock()
+	h.presence.moveFile(roomID, from, to)
+
+	h.broadcastAll(roomID, userID, "file-moved", FileMove{From: from, To: to})
+}
+
+// FileDeleted drops a file or folder of a live room and tells its users
+func (h *Hub) FileDeleted(roomID, userID int64, path string) {
+	h.docMutex.Lock()
+	if room, exists := h.documents[roomID]; exists && room.loading() {
+		// The room is loaded again in case the load missed this
+		room.stale = true
+	} else if exists {
+		for p := range room.tree {
+			if inside(p, path) {
+				delete(room.tree, p)
+			}
+		}
+		for p := range room.files {
+			if inside(p, path) {
+				delete(room.files, p)
+			}
+		}
+	}
+	h.docMutex.Unlock()
+	h.presence.moveFile(roomID, path, "")
+
+	h.broadcastAll(roomID, userID, "file-deleted", map[string]string{"path": path})
+}
@@ -0,0 +1,176 @@
+package sockets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+)
+
+// Every this many revisions a recorded edit carries the full text
+const checkpointEvery = 100
+
+// Recorded edits buffered before editing blocks on the history writer
+const historyBufferSize = 4096
+
+// Recorded edits written to the database in one transaction
+const historyBatchSize = 200
+
+var ErrCorruptHistory = errors.New("revision log does not apply cleanly")
+
+// checkpointer decides which recorded edits of a document carry a copy of
+// the text. The first edit after a document is loaded always does, so the
+// log can be replayed even if older entries are missing.
+type checkpointer struct {
+	started bool
+	last    int
+}
+
+func (c *checkpointer) due(revision int) bool {
+	if !c.started || revision-c.last >= checkpointEvery {
+		c.started = true
+		c.last = revision
+		return true
+	}
+	return false
+}
+
+// recordEdit queues an applied edit for the revision log. Called by the
+// documents with their lock held, so entries are queued in order. text is
+// only called when the entry needs a checkpoint; a nil checkpointer never
+// takes one.
+func (h *Hub) recordEdit(roomID int64, path string, userID int64, revision int,
+	op TextOperation, checkpoints *checkpointer, text func() string) {
+	if h.db == nil {
+		return
+	}
+
+	entry := store.DocumentRevision{
+		RoomId:    roomID,
+		Path:      path,
+		Revision:  revision,
+		UserId:    userID,
+		Position:  op.Position,
+		Delete:    op.Delete,
+		Insert:    op.Insert,
+		CreatedAt: time.Now(),
+	}
+	if checkpoints != nil && checkpoints.due(revision) {
+		snapshot := text()
+		entry.Snapshot = &snapshot
+	}
+	h.revisions <- entry
+}
+
+// RunHistory writes recorded edits to the database in batches
+func (h *Hub) RunHistory() {
+	for entry := range h.revisions {
+		batch := []store.DocumentRevision{entry}
+		batch = h.drainRevisions(batch)
+		h.writeRevisions(batch)
+	}
+}
+
+// FlushHistory writes the recorded edits still waiting in the buffer
+func (h *Hub) FlushHistory() {
+	for {
+		batch := h.drainRevisions(nil)
+		if len(batch) == 0 {
+			return
+		}
+		h.writeRevisions(batch)
+	}
+}
+
+func (h *Hub) drainRevisions(batch []store.DocumentRevision) []store.DocumentRevision {
+	for len(batch) < historyBatchSize {
+		select {
+		case entry := <-h.revisions:
+			batch = append(batch, entry)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (h *Hub) writeRevisions(batch []store.DocumentRevision) {
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	if err := h.db.DocumentStore.AppendRevisions(ctx, batch); err != nil {
+		log.Printf("Writing %d revisions failed: %v", len(batch), err)
+	}
+}
+
+// RebuildContent replays revisions, as returned by
+// store.GetRevisionsUpTo, into the text they produce.
+func RebuildContent(revisions []store.DocumentRevision) (string, error) {
+	var text []rune
+	for _, rev := range revisions {
+		if rev.Snapshot != nil {
+			text = []rune(*rev.Snapshot)
+			continue
+		}
+		if rev.Position < 0 || rev.Delete < 0 || rev.Position+rev.Delete > len(text) {
+			return "", ErrCorruptHistory
+		}
+		text = replaceRunes(text, rev.Position, rev.Delete, rev.Insert)
+	}
+	return string(text), nil
+}
+
+// replayTail applies the revisions logged after a snapshot to its text and
+// returns the result along with the latest revision. An edit that does not
+// apply, e.g. because the batch before it was lost, is skipped along with
+// the rest up to the next checkpoint.
+func replayTail(content string, tail []store.DocumentRevision) (string, int, error) {
+	text := []rune(content)
+	var err error
+	for _, rev := range tail {
+		switch {
+		case rev.Snapshot != nil:
+			text, err = []rune(*rev.Snapshot), nil
+		case err != nil:
+		case rev.Position < 0 || rev.Delete < 0 || rev.Position+rev.Delete > len(text):
+			err = fmt.Errorf("%w at revision %d", ErrCorruptHistory, rev.Revision)
+		default:
+			text = replaceRunes(text, rev.Position, rev.Delete, rev.Insert)
+		}
+	}
+	return string(text), tail[len(tail)-1].Revision, err
+}
+
+// FileContent returns the current text of a file of a room
+func (h *Hub) FileContent(roomID int64, engine, path string) (string, error) {
+	if err := h.acquireRoom(roomID, engine); err != nil {
+		return "", err
+	}
+	defer h.releaseRoom(roomID)
+
+	doc, err := h.document(roomID, path)
+	if err != nil {
+		return "", err
+	}
+	content, _ := doc.Content()
+	return content, nil
+}
+
+// ReplaceContent rewrites a file of a room to content as an edit by
+// userID. Connected clients receive it like any other edit. It returns the
+// new revision.
+func (h *Hub) ReplaceContent(roomID int64, engine, path string, userID int64, content string) (int, error) {
+	if err := h.acquireRoom(roomID, engine); err != nil {
+		return 0, err
+	}
+	defer h.releaseRoom(roomID)
+
+	doc, err := h.document(roomID, path)
+	if err != nil {
+		return 0, err
+	}
+	return doc.Replace(h, userID, content), nil
+}
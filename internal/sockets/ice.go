@@ -0,0 +1,70 @@
+package sockets
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultSTUNURL = "stun:stun.l.google.com:19302"
+	DefaultTURNTTL = time.Hour
+)
+
+// ICEConfig lists the STUN and TURN servers voice chat peers use. TURN
+// credentials are made with the TURN REST API scheme coturn implements
+// as use-auth-secret, so Secret must match its static-auth-secret.
+type ICEConfig struct {
+	STUNURLs []string
+	TURNURLs []string
+	Secret   string
+	TTL      time.Duration
+}
+
+// ICEServer is an RTCIceServer for the browser
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// ICEServers are the servers a user may use, with TURN credentials that
+// stop working at ExpiresAt
+type ICEServers struct {
+	Servers   []ICEServer `json:"ice_servers"`
+	TTL       int         `json:"ttl"` // seconds
+	ExpiresAt time.Time   `json:"expires_at,omitempty"`
+}
+
+// ICEServers returns the servers for userID in roomID. The TURN username
+// is "<expiry>:<room>-<user>", so the TURN server's logs show whose
+// relay it is.
+func (cfg ICEConfig) ICEServers(roomID, userID int64, now time.Time) ICEServers {
+	servers := ICEServers{Servers: []ICEServer{}}
+	if len(cfg.STUNURLs) > 0 {
+		servers.Servers = append(servers.Servers, ICEServer{URLs: cfg.STUNURLs})
+	}
+	if len(cfg.TURNURLs) == 0 || cfg.Secret == "" {
+		return servers
+	}
+
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = DefaultTURNTTL
+	}
+	expiry := now.Add(ttl).Truncate(time.Second)
+	username := fmt.Sprintf("%d:%d-%d", expiry.Unix(), roomID, userID)
+	mac := hmac.New(sha1.New, []byte(cfg.Secret))
+	mac.Write([]byte(username))
+
+	servers.Servers = append(servers.Servers, ICEServer{
+		URLs:       cfg.TURNURLs,
+		Username:   username,
+		Credential: base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+	})
+	servers.TTL = int(ttl.Seconds())
+	servers.ExpiresAt = expiry
+	return servers
+}
@@ -0,0 +1,99 @@
+package sockets
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Bytes of input that may wait for an interactive program to read them
+const maxPendingInput = 64 << 10
+
+var (
+	ErrNotInteractive = errors.New("execution does not take input")
+	ErrJobNotRunning  = errors.New("execution is not running")
+	ErrInputFull      = errors.New("too much input waiting, the program is not reading it")
+)
+
+// ExecutionInput is the payload of "execution-input" messages, typed into
+// a running interactive job. EOF closes the job's stdin after Data.
+type ExecutionInput struct {
+	JobID string `json:"job_id"`
+	Data  string `json:"data"`
+	EOF   bool   `json:"eof"`
+}
+
+// inputBuffer holds input for an interactive job until the program reads
+// it. Writes never block, so a program that does not read cannot stall
+// the room's connections.
+type inputBuffer struct {
+	data   []byte
+	closed bool
+	mutex  sync.Mutex
+	ready  *sync.Cond
+}
+
+func newInputBuffer() *inputBuffer {
+	b := &inputBuffer{}
+	b.ready = sync.NewCond(&b.mutex)
+	return b
+}
+
+func (b *inputBuffer) Read(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for len(b.data) == 0 && !b.closed {
+		b.ready.Wait()
+	}
+	if len(b.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	return n, nil
+}
+
+// write queues data for the program
+func (b *inputBuffer) write(data string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return ErrJobNotRunning
+	}
+	if len(b.data)+len(data) > maxPendingInput {
+		return ErrInputFull
+	}
+	b.data = append(b.data, data...)
+	b.ready.Broadcast()
+	return nil
+}
+
+// close ends the input once what is queued has been read
+func (b *inputBuffer) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	b.ready.Broadcast()
+}
+
+// HandleExecutionInput passes an "execution-input" message on to the
+// job it is for. What was typed is shown to the room as the job's
+// "stdin" output.
+func (h *Hub) HandleExecutionInput(c *Connection, msg WSMessage) {
+	var input ExecutionInput
+	if err := decodeData(msg.Data, &input); err != nil {
+		h.sendError(c, msg.Type, "malformed execution-input payload")
+		return
+	}
+	if h.executions == nil {
+		h.sendError(c, msg.Type, ErrJobNotFound.Error())
+		return
+	}
+
+	if err := h.executions.Input(c.RoomID, input); err != nil {
+		h.sendError(c, msg.Type, err.Error())
+	}
+}
@@ -0,0 +1,440 @@
+package sockets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+	"github.com/google/uuid"
+)
+
+// Execution job states
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobFinished  = "finished"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+const (
+	DefaultExecutionWorkers = 4
+	DefaultExecutionQueue   = 64
+	// Longest a job may run, whatever the executor does
+	executionTimeout = 2 * time.Minute
+	// Longest an interactive job may run, whatever the executor does
+	interactiveTimeout = DefaultInteractiveTimeout + executionTimeout
+	// Finished jobs can be looked up for this long
+	jobRetention = 10 * time.Minute
+	// Longest stdin, stdout or stderr kept in the execution history
+	maxRecordedOutput = 64 << 10
+)
+
+var (
+	ErrQueueFull   = errors.New("too many executions queued, try again later")
+	ErrJobNotFound = errors.New("execution not found")
+	ErrJobDone     = errors.New("execution already finished")
+	// Interactive jobs hold a worker while people type, so only some of
+	// the workers may run them
+	ErrTooManyInteractive     = errors.New("too many interactive executions running, try again later")
+	ErrInteractiveUnsupported = errors.New("interactive executions need the local executor")
+)
+
+// ExecutionJob is a run of code submitted from a room
+type ExecutionJob struct {
+	ID          string           `json:"id"`
+	RoomID      int64            `json:"room_id"`
+	UserID      int64            `json:"user_id"`
+	Language    string           `json:"language"`
+	Interactive bool             `json:"interactive"`
+	ProblemID   int64            `json:"problem_id,omitempty"`
+	Status      string           `json:"status"`
+	Result      *ExecuteResponse `json:"result,omitempty"`
+	Submission  *Submission      `json:"submission,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	StartedAt   *time.Time       `json:"started_at,omitempty"`
+	FinishedAt  *time.Time       `json:"finished_at,omitempty"`
+
+	request ExecuteRequest
+	problem *store.Problem // judged against, for submissions
+	charge  store.UsageCharge
+	cancel  context.CancelFunc
+	relay   *outputRelay
+	input   *inputBuffer // stdin of a running interactive job
+}
+
+// done reports whether the job reached a final state
+func (j *ExecutionJob) done() bool {
+	return j.Status == JobFinished || j.Status == JobFailed || j.Status == JobCancelled
+}
+
+// ExecutionQueue runs execution jobs on a fixed number of workers. Jobs
+// beyond what the queue holds are refused rather than piling up.
+// Everyone in a job's room is told when it starts and finishes with
+// "execution-started" and "execution-finished", and gets its output as
+// "execution-output" in between. Submissions are judged rather than have
+// their output relayed, and "submission-case" tells the room each verdict.
+// Finished jobs are recorded in the room's execution history.
+type ExecutionQueue struct {
+	executor Executor
+	hub      *Hub
+	db       *store.Storage
+	workers  int
+	queue    chan *ExecutionJob
+	// Map of job id -> job, including recently finished ones
+	jobs  map[string]*ExecutionJob
+	mutex sync.Mutex
+}
+
+func NewExecutionQueue(executor Executor, hub *Hub, db *store.Storage, workers, size int) *ExecutionQueue {
+	q := &ExecutionQueue{
+		executor: executor,
+		hub:      hub,
+		db:       db,
+		workers:  workers,
+		queue:    make(chan *ExecutionJob, size),
+		jobs:     make(map[string]*ExecutionJob),
+	}
+	if hub != nil {
+		hub.executions = q
+	}
+	return q
+}
+
+// Run starts the workers and blocks
+func (q *ExecutionQueue) Run() {
+	for i := 1; i < q.workers; i++ {
+		go q.work()
+	}
+	q.work()
+}
+
+// Submit queues req as a job of userID in roomID and returns it. charge is
+// what the job counted towards the daily quotas, it is refunded if the
+// executor fails to run the job or it is cancelled before it runs.
+func (q *ExecutionQueue) Submit(roomID, userID int64, req ExecuteRequest,
+	charge store.UsageCharge) (ExecutionJob, error) {
+	job := &ExecutionJob{
+		ID:          uuid.New().String(),
+		RoomID:      roomID,
+		UserID:      userID,
+		Language:    req.Language,
+		Interactive: req.Interactive,
+		Status:      JobQueued,
+		CreatedAt:   time.Now(),
+		request:     req,
+		charge:      charge,
+	}
+	if _, ok := q.executor.(InteractiveExecutor); req.Interactive && !ok {
+		return ExecutionJob{}, ErrInteractiveUnsupported
+	}
+	return q.enqueue(job)
+}
+
+// SubmitJudge queues a job of userID in roomID judging req against
+// problem and returns it. charge is refunded like Submit's.
+func (q *ExecutionQueue) SubmitJudge(roomID, userID int64, req ExecuteRequest,
+	problem *store.Problem, charge store.UsageCharge) (ExecutionJob, error) {
+	req.Interactive = false
+	job := &ExecutionJob{
+		ID:        uuid.New().String(),
+		RoomID:    roomID,
+		UserID:    userID,
+		Language:  req.Language,
+		ProblemID: problem.Id,
+		Status:    JobQueued,
+		CreatedAt: time.Now(),
+		request:   req,
+		problem:   problem,
+		charge:    charge,
+	}
+	return q.enqueue(job)
+}
+
+func (q *ExecutionQueue) enqueue(job *ExecutionJob) (ExecutionJob, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.prune()
+	if job.Interactive && q.interactiveJobs() >= max(q.workers/2, 1) {
+		return ExecutionJob{}, ErrTooManyInteractive
+	}
+	select {
+	case q.queue <- job:
+	default:
+		return ExecutionJob{}, ErrQueueFull
+	}
+	q.jobs[job.ID] = job
+	return *job, nil
+}
+
+// Get returns a job of a room
+func (q *ExecutionQueue) Get(roomID int64, jobID string) (ExecutionJob, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job, exists := q.jobs[jobID]
+	if !exists || job.RoomID != roomID {
+		return ExecutionJob{}, ErrJobNotFound
+	}
+	return *job, nil
+}
+
+// Input passes input typed in a room to one of its running interactive
+// jobs
+func (q *ExecutionQueue) Input(roomID int64, input ExecutionInput) error {
+	q.mutex.Lock()
+	job, exists := q.jobs[input.JobID]
+	if !exists || job.RoomID != roomID {
+		q.mutex.Unlock()
+		return ErrJobNotFound
+	}
+	if !job.Interactive {
+		q.mutex.Unlock()
+		return ErrNotInteractive
+	}
+	if job.Status != JobRunning || job.input == nil {
+		q.mutex.Unlock()
+		return ErrJobNotRunning
+	}
+	buffer, relay := job.input, job.relay
+	q.mutex.Unlock()
+
+	if err := buffer.write(input.Data); err != nil {
+		return err
+	}
+	relay.write("stdin", input.Data)
+	if input.EOF {
+		buffer.close()
+	}
+	return nil
+}
+
+// interactiveJobs counts the interactive jobs that are queued or
+// running. The caller must hold q.mutex.
+func (q *ExecutionQueue) interactiveJobs() int {
+	count := 0
+	for _, job := range q.jobs {
+		if job.Interactive && !job.done() {
+			count++
+		}
+	}
+	return count
+}
+
+// Cancel stops a job of a room, whether it is waiting or running
+func (q *ExecutionQueue) Cancel(roomID int64, jobID string) (ExecutionJob, error) {
+	q.mutex.Lock()
+	job, exists := q.jobs[jobID]
+	if !exists || job.RoomID != roomID {
+		q.mutex.Unlock()
+		return ExecutionJob{}, ErrJobNotFound
+	}
+	if job.done() {
+		q.mutex.Unlock()
+		return *job, ErrJobDone
+	}
+
+	if job.cancel != nil {
+		job.cancel()
+	}
+	now := time.Now()
+	queued := job.Status == JobQueued
+	job.Status = JobCancelled
+	job.FinishedAt = &now
+	relay, cancelled := job.relay, *job
+	q.mutex.Unlock()
+
+	if relay != nil {
+		relay.close()
+	}
+	if queued {
+		q.refund(cancelled)
+	}
+	q.announce("execution-finished", cancelled)
+	q.record(cancelled)
+	return cancelled, nil
+}
+
+// announce tells the job's room about it
+func (q *ExecutionQueue) announce(msgType string, job ExecutionJob) {
+	if q.hub != nil {
+		q.hub.broadcastAll(job.RoomID, job.UserID, msgType, job)
+	}
+}
+
+// prune forgets jobs that finished a while ago. The caller must hold
+// q.mutex.
+func (q *ExecutionQueue) prune() {
+	for id, job := range q.jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > jobRetention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func (q *ExecutionQueue) work() {
+	for job := range q.queue {
+		q.execute(job)
+	}
+}
+
+func (q *ExecutionQueue) execute(job *ExecutionJob) {
+	timeout := executionTimeout
+	if job.Interactive {
+		timeout = interactiveTimeout
+	} else if job.problem != nil {
+		timeout = judgeTimeout(job.problem)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	q.mutex.Lock()
+	if job.Status == JobCancelled {
+		q.mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	job.Status = JobRunning
+	job.StartedAt = &now
+	job.cancel = cancel
+	if job.problem == nil {
+		// Output of hidden cases must not reach the room
+		job.relay = newOutputRelay(q.hub, *job)
+	}
+	if job.Interactive {
+		job.input = newInputBuffer()
+	}
+	relay, input, started := job.relay, job.input, *job
+	q.mutex.Unlock()
+	q.announce("execution-started", started)
+
+	var result *ExecuteResponse
+	var submission *Submission
+	var err error
+	if job.problem != nil {
+		submission, err = Judge(ctx, q.executor, job.request, job.problem, func(verdict CaseVerdict) {
+			if q.hub != nil {
+				q.hub.broadcastAll(started.RoomID, started.UserID, "submission-case",
+					SubmissionCase{JobID: started.ID, CaseVerdict: verdict})
+			}
+		})
+	} else if interactive, ok := q.executor.(InteractiveExecutor); ok && input != nil {
+		result, err = interactive.ExecuteInteractive(ctx, job.request, input, relay.write)
+		input.close()
+	} else if streaming, ok := q.executor.(StreamingExecutor); ok {
+		result, err = streaming.ExecuteStream(ctx, job.request, relay.write)
+	} else {
+		result, err = q.executor.ExecuteCode(ctx, job.request)
+		if err == nil {
+			// All the output arrives at once
+			relay.write("stdout", result.Output)
+			relay.write("stderr", result.Error)
+		}
+	}
+	if relay != nil {
+		relay.close()
+	}
+
+	q.mutex.Lock()
+	job.cancel = nil
+	job.relay = nil
+	job.input = nil
+	if job.Status == JobCancelled {
+		// Cancel already told the room
+		q.mutex.Unlock()
+		return
+	}
+	finishedAt := time.Now()
+	job.FinishedAt = &finishedAt
+	if err != nil {
+		log.Printf("Execution %s of room %d failed: %v", job.ID, job.RoomID, err)
+		job.Status = JobFailed
+		job.Error = "error executing code"
+		if errors.Is(err, context.DeadlineExceeded) {
+			job.Error = "execution took too long"
+		}
+	} else {
+		job.Status = JobFinished
+		job.Result = result
+		job.Submission = submission
+	}
+	finished := *job
+	q.mutex.Unlock()
+
+	q.announce("execution-finished", finished)
+	q.record(finished)
+	// A job that ran out of time did run
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		q.refund(finished)
+	}
+}
+
+// refund gives back what a job that did not run counted towards the
+// daily quotas
+func (q *ExecutionQueue) refund(job ExecutionJob) {
+	if q.db == nil {
+		return
+	}
+	if err := q.db.UsageStore.Refund(context.Background(), job.charge); err != nil {
+		log.Printf("Error refunding execution %s: %v", job.ID, err)
+	}
+}
+
+// record saves a finished job in the execution history
+func (q *ExecutionQueue) record(job ExecutionJob) {
+	if q.db == nil {
+		return
+	}
+
+	hash := sha256.Sum256([]byte(job.request.Code))
+	exec := &store.Execution{
+		JobId:      job.ID,
+		RoomId:     job.RoomID,
+		UserId:     job.UserID,
+		Language:   job.Language,
+		CodeHash:   hex.EncodeToString(hash[:]),
+		Stdin:      truncateOutput(job.request.Input),
+		Status:     job.Status,
+		CreatedAt:  job.CreatedAt,
+		FinishedAt: *job.FinishedAt,
+	}
+	if result := job.Result; result != nil {
+		exitCode := result.ExitCode
+		exec.Stdout = truncateOutput(result.Output)
+		exec.Stderr = truncateOutput(result.Error)
+		resultStatus := result.Status
+		exec.ResultStatus = &resultStatus
+		exec.ExitCode = &exitCode
+		if seconds, err := strconv.ParseFloat(result.Runtime, 64); err == nil {
+			runtime := int(seconds * 1000)
+			exec.RuntimeMs = &runtime
+		}
+		if result.Memory > 0 {
+			memory := result.Memory
+			exec.MemoryKb = &memory
+		}
+	} else if job.Error != "" {
+		exec.Stderr = job.Error
+	}
+
+	if err := q.db.ExecutionStore.Create(context.Background(), exec); err != nil {
+		log.Printf("Error recording execution %s: %v", job.ID, err)
+	}
+}
+
+// truncateOutput cuts s down to what the execution history keeps
+func truncateOutput(s string) string {
+	if len(s) <= maxRecordedOutput {
+		return s
+	}
+	head, _ := splitIncompleteRune([]byte(s[:maxRecordedOutput]))
+	return string(head)
+}
@@ -0,0 +1,168 @@
+package sockets
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+)
+
+// Verdicts of a judged test case
+const (
+	VerdictAccepted     = "AC"
+	VerdictWrongAnswer  = "WA"
+	VerdictTimeLimit    = "TLE"
+	VerdictRuntimeError = "RE"
+	VerdictCompileError = "CE"
+)
+
+// CaseVerdict is how code did on one test case. The input and outputs
+// are only shown for sample cases.
+type CaseVerdict struct {
+	Case     int    `json:"case"`
+	Hidden   bool   `json:"hidden"`
+	Verdict  string `json:"verdict"`
+	Runtime  string `json:"runtime,omitempty"`
+	Memory   int    `json:"memory,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Input    string `json:"input,omitempty"`
+	Output   string `json:"output,omitempty"`
+	Expected string `json:"expected,omitempty"`
+}
+
+// Submission is the result of judging code against a problem. Its
+// verdict is that of the first case that was not accepted.
+type Submission struct {
+	ProblemID int64         `json:"problem_id"`
+	Verdict   string        `json:"verdict"`
+	Passed    int           `json:"passed"`
+	Total     int           `json:"total"`
+	Cases     []CaseVerdict `json:"cases"`
+}
+
+// SubmissionCase is the payload of "submission-case" messages, sent to
+// the room as each case of a submission job is judged
+type SubmissionCase struct {
+	JobID string `json:"job_id"`
+	CaseVerdict
+}
+
+// Judge runs req against every case of problem in turn, building the code
+// only once if the executor can. judged, if not nil, gets the verdict of
+// each case as soon as it is known. Once the code fails to compile the
+// remaining cases are not run.
+func Judge(ctx context.Context, executor Executor, req ExecuteRequest,
+	problem *store.Problem, judged func(CaseVerdict)) (*Submission, error) {
+	submission := &Submission{
+		ProblemID: problem.Id,
+		Verdict:   VerdictAccepted,
+		Total:     len(problem.Cases),
+		Cases:     make([]CaseVerdict, 0, len(problem.Cases)),
+	}
+	add := func(verdict CaseVerdict) {
+		verdict.Case = len(submission.Cases) + 1
+		if verdict.Verdict == VerdictAccepted {
+			submission.Passed++
+		} else if submission.Verdict == VerdictAccepted {
+			submission.Verdict = verdict.Verdict
+		}
+		submission.Cases = append(submission.Cases, verdict)
+		if judged != nil {
+			judged(verdict)
+		}
+	}
+
+	cases := make([]BatchCase, len(problem.Cases))
+	for i, testCase := range problem.Cases {
+		cases[i] = BatchCase{
+			Input:       testCase.Input,
+			TimeLimit:   time.Duration(testCase.TimeLimitMs) * time.Millisecond,
+			MemoryLimit: testCase.MemoryLimitKb,
+		}
+	}
+	if batch, ok := executor.(BatchExecutor); ok {
+		err := batch.ExecuteBatch(ctx, req, cases, func(i int, result *ExecuteResponse) {
+			add(judgeCase(problem.Cases[i], result))
+		})
+		if err != nil {
+			return nil, err
+		}
+		return submission, nil
+	}
+
+	compiled := true
+	for i, testCase := range problem.Cases {
+		verdict := CaseVerdict{Hidden: testCase.Hidden, Verdict: VerdictCompileError}
+		if compiled {
+			result, err := executor.ExecuteCode(ctx, cases[i].request(req))
+			if err != nil {
+				return nil, err
+			}
+			verdict = judgeCase(testCase, result)
+			compiled = verdict.Verdict != VerdictCompileError
+		}
+		add(verdict)
+	}
+
+	return submission, nil
+}
+
+// judgeTimeout is how long judging problem may take: the time limits of
+// its cases on top of the time any execution gets
+func judgeTimeout(problem *store.Problem) time.Duration {
+	timeout := executionTimeout
+	for _, testCase := range problem.Cases {
+		timeout += time.Duration(testCase.TimeLimitMs) * time.Millisecond
+	}
+	return timeout
+}
+
+// judgeCase gives the verdict of one run
+func judgeCase(testCase store.ProblemCase, result *ExecuteResponse) CaseVerdict {
+	verdict := CaseVerdict{
+		Hidden:  testCase.Hidden,
+		Runtime: result.Runtime,
+		Memory:  result.Memory,
+	}
+	if !testCase.Hidden {
+		verdict.Input = testCase.Input
+		verdict.Output = result.Output
+		verdict.Expected = testCase.ExpectedOutput
+	}
+
+	runtime, err := strconv.ParseFloat(result.Runtime, 64)
+	tooSlow := err == nil && testCase.TimeLimitMs > 0 && runtime*1000 > float64(testCase.TimeLimitMs)
+	switch {
+	case result.Status == "compile_error":
+		verdict.Verdict = VerdictCompileError
+		verdict.Message = result.Error
+	case result.Status == "timeout" || tooSlow:
+		verdict.Verdict = VerdictTimeLimit
+	case testCase.MemoryLimitKb > 0 && result.Memory > testCase.MemoryLimitKb:
+		verdict.Verdict = VerdictRuntimeError
+		verdict.Message = "Memory limit exceeded"
+	case result.Status != "success":
+		verdict.Verdict = VerdictRuntimeError
+		if !testCase.Hidden {
+			// Error output could give the hidden input away
+			verdict.Message = result.Error
+		}
+	case normalizeOutput(result.Output) != normalizeOutput(testCase.ExpectedOutput):
+		verdict.Verdict = VerdictWrongAnswer
+	default:
+		verdict.Verdict = VerdictAccepted
+	}
+	return verdict
+}
+
+// normalizeOutput drops trailing whitespace on every line and trailing
+// blank lines, which judges do not count against an answer
+func normalizeOutput(output string) string {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
@@ -0,0 +1,69 @@
+package sockets
+
+import (
+	"testing"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+)
+
+func TestNormalizeOutput(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"", ""},
+		{"42", "42"},
+		{"42\n", "42"},
+		{"42\n\n\n", "42"},
+		{"1 2 3 \n4\t\n", "1 2 3\n4"},
+		{"a\r\nb\r\n", "a\nb"},
+		{"  indented\n", "  indented"},
+		{"a\n\nb", "a\n\nb"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeOutput(tt.output); got != tt.want {
+			t.Errorf("normalizeOutput(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestJudgeCase(t *testing.T) {
+	sample := store.ProblemCase{Input: "1 2", ExpectedOutput: "3\n", TimeLimitMs: 1000, MemoryLimitKb: 1024}
+	hidden := sample
+	hidden.Hidden = true
+
+	tests := []struct {
+		name     string
+		testCase store.ProblemCase
+		result   ExecuteResponse
+		verdict  string
+		message  string
+	}{
+		{"accepted", sample, ExecuteResponse{Output: "3", Status: "success", Runtime: "0.1"}, VerdictAccepted, ""},
+		{"trailing whitespace", sample, ExecuteResponse{Output: "3 \r\n\n", Status: "success", Runtime: "0.1"}, VerdictAccepted, ""},
+		{"wrong answer", sample, ExecuteResponse{Output: "4", Status: "success", Runtime: "0.1"}, VerdictWrongAnswer, ""},
+		{"compile error", sample, ExecuteResponse{Status: "compile_error", Error: "syntax"}, VerdictCompileError, "syntax"},
+		{"timed out", sample, ExecuteResponse{Status: "timeout"}, VerdictTimeLimit, ""},
+		{"over the time limit", sample, ExecuteResponse{Output: "3", Status: "success", Runtime: "1.5"}, VerdictTimeLimit, ""},
+		{"over the memory limit", sample, ExecuteResponse{Output: "3", Status: "success", Runtime: "0.1", Memory: 2048}, VerdictRuntimeError, "Memory limit exceeded"},
+		{"runtime error", sample, ExecuteResponse{Status: "error", Error: "panic"}, VerdictRuntimeError, "panic"},
+		{"hidden runtime error", hidden, ExecuteResponse{Status: "error", Error: "panic: 1 2"}, VerdictRuntimeError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.result
+			verdict := judgeCase(tt.testCase, &result)
+			if verdict.Verdict != tt.verdict {
+				t.Errorf("verdict = %s, want %s", verdict.Verdict, tt.verdict)
+			}
+			if verdict.Message != tt.message {
+				t.Errorf("message = %q, want %q", verdict.Message, tt.message)
+			}
+			if tt.testCase.Hidden && (verdict.Input != "" || verdict.Output != "" || verdict.Expected != "") {
+				t.Errorf("hidden case leaked %+v", verdict)
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package sockets
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/languages"
+	"github.com/Alter-Sitanshu/CodeEditor/internal/sandbox"
+)
+
+// Limits a LocalExecutor uses unless configured otherwise
+const (
+	DefaultSandboxMemory  = 512 << 20
+	DefaultSandboxCPUs    = 1
+	DefaultSandboxPids    = 128
+	DefaultCompileTimeout = 30 * time.Second
+	DefaultRunTimeout     = 10 * time.Second
+	// Interactive programs wait on people, but not for longer than this
+	DefaultInteractiveTimeout = 5 * time.Minute
+	// Go builds start from an empty build cache, which needs room
+	sandboxWorkSize = 256 << 20
+	// Bytes of output an interactive session may produce
+	interactiveOutputLimit = 1 << 20
+)
+
+// LocalConfig configures the sandbox of a LocalExecutor. Zero values
+// take the defaults.
+type LocalConfig struct {
+	// cgroup v2 directory runs are limited under, required
+	CgroupRoot     string
+	Memory         int64
+	CPUs           float64
+	Pids           int
+	CompileTimeout time.Duration
+	RunTimeout     time.Duration
+	// Wall clock limit of an interactive run
+	InteractiveTimeout time.Duration
+	// PATH the toolchains are looked up in, the server's PATH by default
+	Path string
+	// Host paths every run can read, sandbox.DefaultMounts by default.
+	// The directories of each language's toolchain are added to them.
+	Mounts []string
+	// How each language is built and run, the default languages if nil
+	Languages *languages.Registry
+}
+
+// LocalExecutor compiles and runs code on this machine in a sandbox
+// without network access
+type LocalExecutor struct {
+	sandbox *sandbox.Sandbox
+	config  LocalConfig
+	// Map of language -> host paths its runs can read
+	mounts map[string][]string
+}
+
+func NewLocalExecutor(cfg LocalConfig) (*LocalExecutor, error) {
+	if cfg.Memory <= 0 {
+		cfg.Memory = DefaultSandboxMemory
+	}
+	if cfg.CPUs <= 0 {
+		cfg.CPUs = DefaultSandboxCPUs
+	}
+	if cfg.Pids <= 0 {
+		cfg.Pids = DefaultSandboxPids
+	}
+	if cfg.CompileTimeout <= 0 {
+		cfg.CompileTimeout = DefaultCompileTimeout
+	}
+	if cfg.RunTimeout <= 0 {
+		cfg.RunTimeout = DefaultRunTimeout
+	}
+	if cfg.InteractiveTimeout <= 0 {
+		cfg.InteractiveTimeout = DefaultInteractiveTimeout
+	}
+	if cfg.Path == "" {
+		cfg.Path = os.Getenv("PATH")
+	}
+	if cfg.Languages == nil {
+		cfg.Languages = languages.Default()
+	}
+	if cfg.Mounts == nil {
+		cfg.Mounts = sandbox.DefaultMounts
+	}
+	mounts := make(map[string][]string)
+	for _, lang := range cfg.Languages.List() {
+		if lang.Local() {
+			mounts[lang.Name] = toolchainMounts(lang, cfg)
+		}
+	}
+
+	sb, err := sandbox.New(cfg.CgroupRoot, sandbox.Limits{
+		Memory: cfg.Memory,
+		CPUs:   cfg.CPUs,
+		Pids:   cfg.Pids,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &LocalExecutor{sandbox: sb, config: cfg, mounts: mounts}, nil
+}
+
+// toolchainMounts lists the host paths runs of lang can read: the common
+// ones, those of the language and where its commands are found on the
+// PATH. A command in a bin directory brings the directory above along,
+// where toolchains like Go keep the rest of their files, unless that
+// would show the server's own directory.
+func toolchainMounts(lang languages.Language, cfg LocalConfig) []string {
+	mounts := append(slices.Clone(cfg.Mounts), lang.Mounts...)
+	wd, _ := os.Getwd()
+	showsServer := func(dir string) bool {
+		rel, err := filepath.Rel(dir, wd)
+		return dir == "/" || err == nil && filepath.IsLocal(rel)
+	}
+	for _, args := range [][]string{lang.Compile, lang.Run} {
+		if len(args) == 0 || strings.Contains(args[0], "/") {
+			// Commands with a path are built in the work directory
+			continue
+		}
+		for _, dir := range filepath.SplitList(cfg.Path) {
+			command := filepath.Join(dir, args[0])
+			info, err := os.Stat(command)
+			if err != nil || info.IsDir() || info.Mode()&0o111 == 0 {
+				continue
+			}
+			for _, found := range []string{command, resolvedPath(command)} {
+				dir := filepath.Dir(found)
+				if home := filepath.Dir(dir); filepath.Base(dir) == "bin" && !showsServer(home) {
+					dir = home
+				}
+				if !showsServer(dir) {
+					mounts = append(mounts, dir)
+				}
+			}
+			break
+		}
+	}
+	slices.Sort(mounts)
+	return slices.Compact(mounts)
+}
+
+// resolvedPath is path with its symlinks resolved, or path if they cannot
+// be
+func resolvedPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+func (e *LocalExecutor) ExecuteCode(ctx context.Context, req ExecuteRequest) (*ExecuteResponse, error) {
+	return e.ExecuteStream(ctx, req, nil)
+}
+
+func (e *LocalExecutor) ExecuteStream(ctx context.Context, req ExecuteRequest,
+	output OutputFunc) (*ExecuteResponse, error) {
+	spec := e.spec(req, false)
+	if spec == nil {
+		return unsupportedLanguage(), nil
+	}
+
+	result, err := e.sandbox.Run(ctx, spec, sandboxOutput(output))
+	if err != nil {
+		return nil, err
+	}
+	return localResponse(spec, result), nil
+}
+
+// ExecuteInteractive runs req with the program reading stdin as it
+// arrives, until stdin ends or the interactive timeout passes. req.Input
+// is ignored.
+func (e *LocalExecutor) ExecuteInteractive(ctx context.Context, req ExecuteRequest,
+	stdin io.Reader, output OutputFunc) (*ExecuteResponse, error) {
+	spec := e.spec(req, true)
+	if spec == nil {
+		return unsupportedLanguage(), nil
+	}
+
+	result, err := e.sandbox.RunInteractive(ctx, spec, stdin, sandboxOutput(output))
+	if err != nil {
+		return nil, err
+	}
+	return localResponse(spec, result), nil
+}
+
+// ExecuteBatch compiles req once and runs it against every case in the
+// same sandbox. Each case starts from the work directory the build left,
+// so no case sees what another wrote. The run is limited to the most
+// memory any case allows.
+func (e *LocalExecutor) ExecuteBatch(ctx context.Context, req ExecuteRequest, cases []BatchCase,
+	done func(i int, response *ExecuteResponse)) error {
+	spec := e.spec(req, false)
+	if spec == nil {
+		for i := range cases {
+			done(i, unsupportedLanguage())
+		}
+		return nil
+	}
+
+	// The steps before the run step build the code
+	build := len(spec.Steps) - 1
+	batch := *spec
+	batch.Steps = append([]sandbox.Step{}, spec.Steps[:build]...)
+	batch.Memory = 0
+	for i, c := range cases {
+		run := e.spec(c.request(req), false)
+		step := run.Steps[len(run.Steps)-1]
+		step.KeepGoing = true
+		step.Fresh = true
+		batch.Steps = append(batch.Steps, step)
+		if i == 0 || (batch.Memory > 0 && (run.Memory == 0 || run.Memory > batch.Memory)) {
+			batch.Memory = run.Memory
+		}
+	}
+
+	ran := 0
+	result, err := e.sandbox.RunSteps(ctx, &batch, nil, func(step int, stepResult sandbox.StepResult) {
+		if step >= build {
+			done(step-build, runResponse(stepResult))
+			ran++
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if ran == 0 && len(result.Steps) <= build {
+		// Only the build ran, so it failed
+		response := localResponse(spec, result)
+		for i := range cases {
+			done(i, response)
+		}
+		return nil
+	}
+	if ran < len(cases) {
+		return fmt.Errorf("sandbox stopped after %d of %d cases", ran, len(cases))
+	}
+	return nil
+}
+
+// runResponse describes the run of a program that was built
+func runResponse(step sandbox.StepResult) *ExecuteResponse {
+	response := &ExecuteResponse{
+		Output:   step.Stdout,
+		ExitCode: step.ExitCode,
+		Runtime:  fmt.Sprintf("%.3f", step.WallTime.Seconds()),
+		Memory:   int(step.MaxRSS),
+	}
+	switch {
+	case step.OOMKilled:
+		response.Status = "error"
+		response.Error = "Memory limit exceeded"
+	case step.TimedOut:
+		response.Status = "timeout"
+		response.Error = "Time limit exceeded"
+	case step.ExitCode != 0:
+		response.Status = "error"
+		response.Error = step.Stderr
+	default:
+		response.Status = "success"
+	}
+	if step.Truncated {
+		response.Error += "\n(output truncated)"
+	}
+	return response
+}
+
+func unsupportedLanguage() *ExecuteResponse {
+	return &ExecuteResponse{
+		Error:  "Unsupported language",
+		Status: "error",
+	}
+}
+
+// sandboxOutput passes the output of every step on to output
+func sandboxOutput(output OutputFunc) sandbox.Output {
+	if output == nil {
+		return nil
+	}
+	return func(step int, name string, data []byte) {
+		output(name, string(data))
+	}
+}
+
+// spec describes how req is built and run in the sandbox, nil if its
+// language is not supported
+func (e *LocalExecutor) spec(req ExecuteRequest, interactive bool) *sandbox.Spec {
+	lang, err := e.config.Languages.Get(req.Language)
+	if err != nil || !lang.Local() {
+		return nil
+	}
+
+	spec := &sandbox.Spec{
+		Files:    []sandbox.File{{Path: lang.File, Content: req.Code}},
+		Mounts:   e.mounts[lang.Name],
+		WorkSize: sandboxWorkSize,
+		Env: []string{
+			"PATH=" + e.config.Path,
+			"HOME=" + sandbox.WorkDir,
+			"GOCACHE=" + sandbox.WorkDir + "/.cache/go-build",
+			"GOPATH=" + sandbox.WorkDir + "/go",
+			"GOTOOLCHAIN=local",
+			"CGO_ENABLED=0",
+			"PYTHONUNBUFFERED=1",
+		},
+	}
+	if req.MemoryLimit > 0 {
+		spec.Memory = int64(req.MemoryLimit) << 10
+	}
+	if lang.Compile != nil {
+		spec.Steps = append(spec.Steps, sandbox.Step{
+			Args:    lang.Compile,
+			Timeout: e.config.CompileTimeout,
+		})
+	}
+	run := sandbox.Step{
+		Args:    lang.Run,
+		Stdin:   req.Input,
+		Timeout: e.config.RunTimeout,
+	}
+	// A problem may lower the run timeout but never raise it
+	if req.TimeLimit > 0 {
+		run.Timeout = min(e.config.RunTimeout, req.TimeLimit)
+	}
+	if interactive {
+		run.Stdin = ""
+		run.Interactive = true
+		run.Timeout = e.config.InteractiveTimeout
+		spec.OutputLimit = interactiveOutputLimit
+	}
+	spec.Steps = append(spec.Steps, run)
+
+	return spec
+}
+
+// localResponse describes a sandbox run the way Judge0 results are
+func localResponse(spec *sandbox.Spec, result *sandbox.Result) *ExecuteResponse {
+	last := result.Last()
+	response := &ExecuteResponse{
+		ExitCode: last.ExitCode,
+		Runtime:  fmt.Sprintf("%.3f", last.WallTime.Seconds()),
+		Memory:   int(last.MaxRSS),
+	}
+	compiled := len(spec.Steps) == 1 || len(result.Steps) == len(spec.Steps)
+	if compiled {
+		response.Output = last.Stdout
+	}
+
+	switch {
+	case result.Success(spec):
+		response.Status = "success"
+	case result.OOMKilled:
+		response.Status = "error"
+		response.Error = "Memory limit exceeded"
+	case last.TimedOut:
+		response.Status = "timeout"
+		response.Error = "Time limit exceeded"
+	case !compiled:
+		response.Status = "compile_error"
+		response.Error = "Compilation failed\n" + last.Stderr + last.Stdout
+	default:
+		response.Status = "error"
+		response.Error = last.Stderr
+	}
+	if last.Truncated {
+		response.Error += "\n(output truncated)"
+	}
+
+	return response
+}
@@ -0,0 +1,171 @@
+package sockets
+
+import (
+	"errors"
+	"time"
+)
+
+// Kinds of media track a participant can publish
+const (
+	MediaAudio  = "audio"
+	MediaVideo  = "video"
+	MediaScreen = "screen"
+)
+
+// Tracks a participant can publish at once
+const MaxMediaTracks = 8
+
+// Longest track and stream id accepted, browsers use 36 character UUIDs
+const maxMediaIDLength = 128
+
+var (
+	ErrUnknownMediaKind = errors.New("media kind must be audio, video or screen")
+	ErrInvalidTrack     = errors.New("track_id is required and stream_id must be short")
+	ErrTooManyTracks    = errors.New("too many published tracks")
+	ErrUnknownTrack     = errors.New("track is not published")
+)
+
+// MediaTrack is a track a participant sends to the other peers. TrackID
+// and StreamID are those of the sender's MediaStreamTrack and MediaStream,
+// so receivers can tell a screen from a camera in their ontrack handler.
+type MediaTrack struct {
+	TrackID     string    `json:"track_id"`
+	StreamID    string    `json:"stream_id,omitempty"`
+	Kind        string    `json:"kind"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// MediaUnpublishData is the payload of "media-unpublish"
+type MediaUnpublishData struct {
+	TrackID string `json:"track_id"`
+}
+
+// MediaEvent is the payload of "media-published" and "media-unpublished"
+type MediaEvent struct {
+	UserID int64      `json:"user_id"`
+	Track  MediaTrack `json:"track"`
+	// Tracks the user publishes after the change
+	Tracks []MediaTrack `json:"tracks"`
+}
+
+// PublishTrack adds a track to a participant, or replaces the one with the
+// same id. Participants muted by a moderator cannot publish audio. It
+// returns the participant's tracks after the change.
+func (vcm *VoiceChatManager) PublishTrack(roomID, userID int64, track MediaTrack) ([]MediaTrack, error) {
+	if track.Kind != MediaAudio && track.Kind != MediaVideo && track.Kind != MediaScreen {
+		return nil, ErrUnknownMediaKind
+	}
+	if track.TrackID == "" || len(track.TrackID) > maxMediaIDLength || len(track.StreamID) > maxMediaIDLength {
+		return nil, ErrInvalidTrack
+	}
+
+	vcm.mutex.RLock()
+	defer vcm.mutex.RUnlock()
+
+	voiceChat, exists := vcm.VoiceChats[roomID]
+	if !exists {
+		return nil, ErrNotInVoice
+	}
+	voiceChat.mutex.Lock()
+	defer voiceChat.mutex.Unlock()
+
+	participant, exists := voiceChat.Participants[userID]
+	if !exists {
+		return nil, ErrNotInVoice
+	}
+	if track.Kind == MediaAudio && participant.ServerMuted {
+		return nil, ErrServerMuted
+	}
+
+	// Tracks are copied on write, participants are marshalled unlocked
+	tracks := make([]MediaTrack, 0, len(participant.Tracks)+1)
+	for _, t := range participant.Tracks {
+		if t.TrackID != track.TrackID {
+			tracks = append(tracks, t)
+		}
+	}
+	if len(tracks) >= MaxMediaTracks {
+		return nil, ErrTooManyTracks
+	}
+	track.PublishedAt = time.Now()
+	participant.Tracks = append(tracks, track)
+	return participant.Tracks, nil
+}
+
+// UnpublishTrack removes a track from a participant. It returns the
+// removed track and the participant's tracks after the change.
+func (vcm *VoiceChatManager) UnpublishTrack(roomID, userID int64, trackID string) (MediaTrack, []MediaTrack, error) {
+	vcm.mutex.RLock()
+	defer vcm.mutex.RUnlock()
+
+	voiceChat, exists := vcm.VoiceChats[roomID]
+	if !exists {
+		return MediaTrack{}, nil, ErrNotInVoice
+	}
+	voiceChat.mutex.Lock()
+	defer voiceChat.mutex.Unlock()
+
+	participant, exists := voiceChat.Participants[userID]
+	if !exists {
+		return MediaTrack{}, nil, ErrNotInVoice
+	}
+
+	var removed MediaTrack
+	found := false
+	tracks := make([]MediaTrack, 0, len(participant.Tracks))
+	for _, t := range participant.Tracks {
+		if t.TrackID == trackID {
+			removed, found = t, true
+			continue
+		}
+		tracks = append(tracks, t)
+	}
+	if !found {
+		return MediaTrack{}, nil, ErrUnknownTrack
+	}
+	participant.Tracks = tracks
+	return removed, tracks, nil
+}
+
+// HandleMediaMessage applies "media-publish" and "media-unpublish" and
+// tells the room with "media-published" or "media-unpublished". The
+// publisher then renegotiates with each peer by sending it a new
+// "webrtc-offer"; a peer that needs a fresh offer, for instance to
+// subscribe to a screen share it skipped, asks with "webrtc-renegotiate".
+func (h *Hub) HandleMediaMessage(c *Connection, msg WSMessage, vcm *VoiceChatManager) {
+	switch msg.Type {
+	case "media-publish":
+		var track MediaTrack
+		if err := decodeData(msg.Data, &track); err != nil {
+			h.sendError(c, msg.Type, "malformed publish payload")
+			return
+		}
+		tracks, err := vcm.PublishTrack(c.RoomID, c.UserID, track)
+		if err != nil {
+			h.sendError(c, msg.Type, err.Error())
+			return
+		}
+		h.broadcastAll(c.RoomID, c.UserID, "media-published", MediaEvent{
+			UserID: c.UserID,
+			Track:  tracks[len(tracks)-1],
+			Tracks: tracks,
+		})
+
+	case "media-unpublish":
+		var unpublish MediaUnpublishData
+		if err := decodeData(msg.Data, &unpublish); err != nil {
+			h.sendError(c, msg.Type, "malformed unpublish payload")
+			return
+		}
+		track, tracks, err := vcm.UnpublishTrack(c.RoomID, c.UserID, unpublish.TrackID)
+		if err != nil {
+			h.sendError(c, msg.Type, err.Error())
+			return
+		}
+		h.broadcastAll(c.RoomID, c.UserID, "media-unpublished", MediaEvent{
+			UserID: c.UserID,
+			Track:  track,
+			Tracks: tracks,
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package sockets
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrVoiceLocked = errors.New("the voice chat is locked")
+	ErrServerMuted = errors.New("you were muted by a moderator")
+)
+
+// VoiceMuteData is the payload of "voice-mute-user" and "voice-muted"
+type VoiceMuteData struct {
+	UserID int64 `json:"user_id"`
+	Muted  bool  `json:"muted"`
+}
+
+// VoiceRemoveData is the payload of "voice-remove-user" and "voice-removed"
+type VoiceRemoveData struct {
+	UserID int64 `json:"user_id"`
+}
+
+// VoiceLockData is the payload of "voice-lock" and "voice-locked"
+type VoiceLockData struct {
+	Locked bool `json:"locked"`
+}
+
+// state is the VoiceStateUpdate describing p
+func (p *VoiceParticipant) state() VoiceStateUpdate {
+	return VoiceStateUpdate{
+		UserID:     p.UserID,
+		Muted:      p.Muted,
+		Deafened:   p.Deafened,
+		Speaking:   p.Speaking,
+		AudioLevel: p.AudioLevel,
+	}
+}
+
+// SetServerMuted mutes or unmutes a participant on behalf of a moderator.
+// The mute outlives the participant leaving the voice chat. Muting takes
+// down the participant's audio tracks, one event for each is returned.
+func (vcm *VoiceChatManager) SetServerMuted(roomID, userID int64,
+	muted bool) (state VoiceStateUpdate, unpublished []MediaEvent, ok bool) {
+	vcm.mutex.Lock()
+	defer vcm.mutex.Unlock()
+
+	voiceChat, exists := vcm.VoiceChats[roomID]
+	if !exists {
+		return VoiceStateUpdate{}, nil, false
+	}
+	voiceChat.mutex.Lock()
+	defer voiceChat.mutex.Unlock()
+
+	participant, exists := voiceChat.Participants[userID]
+	if !exists {
+		return VoiceStateUpdate{}, nil, false
+	}
+
+	if muted {
+		if vcm.serverMuted[roomID] == nil {
+			vcm.serverMuted[roomID] = make(map[int64]bool)
+		}
+		vcm.serverMuted[roomID][userID] = true
+		participant.Muted = true
+		participant.Speaking = false
+		participant.AudioLevel = 0
+		for _, track := range participant.Tracks {
+			if track.Kind != MediaAudio {
+				continue
+			}
+			// Tracks are copied on write, participants are marshalled unlocked
+			tracks := make([]MediaTrack, 0, len(participant.Tracks))
+			for _, t := range participant.Tracks {
+				if t.TrackID != track.TrackID {
+					tracks = append(tracks, t)
+				}
+			}
+			participant.Tracks = tracks
+			unpublished = append(unpublished, MediaEvent{UserID: userID, Track: track, Tracks: tracks})
+		}
+	} else {
+		delete(vcm.serverMuted[roomID], userID)
+		if len(vcm.serverMuted[roomID]) == 0 {
+			delete(vcm.serverMuted, roomID)
+		}
+		// The user unmutes their microphone themselves
+	}
+	participant.ServerMuted = muted
+	return participant.state(), unpublished, true
+}
+
+// SetLocked locks or unlocks the voice chat of a room
+func (vcm *VoiceChatManager) SetLocked(roomID int64, locked bool) {
+	vcm.mutex.Lock()
+	defer vcm.mutex.Unlock()
+
+	if locked {
+		vcm.locked[roomID] = true
+	} else {
+		delete(vcm.locked, roomID)
+	}
+}
+
+// Locked reports whether the voice chat of a room is locked
+func (vcm *VoiceChatManager) Locked(roomID int64) bool {
+	vcm.mutex.RLock()
+	defer vcm.mutex.RUnlock()
+
+	return vcm.locked[roomID]
+}
+
+// voiceTarget returns the connection of a user in c's room who is in the
+// voice chat and has a lower role than c, or the error to send back.
+func (h *Hub) voiceTarget(c *Connection, userID int64, vcm *VoiceChatManager) (*Connection, error) {
+	h.mutex.RLock()
+	target, exists := h.Rooms[c.RoomID][userID]
+	h.mutex.RUnlock()
+	if !exists || !vcm.InVoiceChat(c.RoomID, userID) {
+		return nil, ErrTargetNotInVoice
+	}
+	if target.Role >= c.Role {
+		return nil, ErrForbidden
+	}
+	return target, nil
+}
+
+// notify sends a message of msgType from c to a single user of c's room
+func (h *Hub) notify(c *Connection, userID int64, msgType string, data any) {
+	notice, _ := json.Marshal(WSMessage{
+		Type:      msgType,
+		RoomID:    c.RoomID,
+		UserID:    c.UserID,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	})
+	h.Unicast <- DirectMessage{
+		RoomID:  c.RoomID,
+		UserID:  userID,
+		Message: notice,
+	}
+}
+
+// HandleVoiceModeration applies "voice-mute-user", "voice-remove-user"
+// and "voice-lock" sent by a moderator. Muted and removed users get a
+// "voice-muted" or "voice-removed" message and the room is told with
+// the same events as when users change their own voice state.
+func (h *Hub) HandleVoiceModeration(c *Connection, msg WSMessage, vcm *VoiceChatManager) {
+	switch msg.Type {
+	case "voice-mute-user":
+		var mute VoiceMuteData
+		if err := decodeData(msg.Data, &mute); err != nil {
+			h.sendError(c, msg.Type, "malformed mute payload")
+			return
+		}
+		if _, err := h.voiceTarget(c, mute.UserID, vcm); err != nil {
+			h.sendError(c, msg.Type, err.Error())
+			return
+		}
+		state, unpublished, ok := vcm.SetServerMuted(c.RoomID, mute.UserID, mute.Muted)
+		if !ok {
+			h.sendError(c, msg.Type, ErrTargetNotInVoice.Error())
+			return
+		}
+		h.notify(c, mute.UserID, "voice-muted", mute)
+		h.broadcastAll(c.RoomID, mute.UserID, "voice-state-updated", state)
+		for _, event := range unpublished {
+			h.broadcastAll(c.RoomID, mute.UserID, "media-unpublished", event)
+		}
+
+	case "voice-remove-user":
+		var remove VoiceRemoveData
+		if err := decodeData(msg.Data, &remove); err != nil {
+			h.sendError(c, msg.Type, "malformed remove payload")
+			return
+		}
+		if _, err := h.voiceTarget(c, remove.UserID, vcm); err != nil {
+			h.sendError(c, msg.Type, err.Error())
+			return
+		}
+		vcm.LeaveVoiceChat(c.RoomID, remove.UserID)
+		h.notify(c, remove.UserID, "voice-removed", remove)
+		h.broadcastAll(c.RoomID, remove.UserID, "voice-user-left", map[string]int64{"user_id": remove.UserID})
+
+	case "voice-lock":
+		var lock VoiceLockData
+		if err := decodeData(msg.Data, &lock); err != nil {
+			h.sendError(c, msg.Type, "malformed lock payload")
+			return
+		}
+		vcm.SetLocked(c.RoomID, lock.Locked)
+		h.broadcastAll(c.RoomID, c.UserID, "voice-locked", lock)
+	}
+}
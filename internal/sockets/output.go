@@ -0,0 +1,135 @@
+package sockets
+
+import (
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Output of a running job is relayed to the room at most this often,
+// or sooner once this much of it is waiting
+const (
+	outputFlushInterval = 100 * time.Millisecond
+	outputFlushSize     = 4096
+)
+
+// ExecutionOutput is the payload of "execution-output" messages
+type ExecutionOutput struct {
+	JobID  string `json:"job_id"`
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+}
+
+type outputChunk struct {
+	stream string
+	data   []byte
+}
+
+// outputRelay batches the output of a job into "execution-output"
+// messages to the job's room, so a chatty program does not flood it
+type outputRelay struct {
+	hub     *Hub
+	job     ExecutionJob
+	pending []outputChunk
+	size    int
+	timer   *time.Timer
+	closed  bool
+	mutex   sync.Mutex
+}
+
+func newOutputRelay(hub *Hub, job ExecutionJob) *outputRelay {
+	return &outputRelay{hub: hub, job: job}
+}
+
+// write queues output of the job; it has the signature of an OutputFunc
+func (r *outputRelay) write(stream, data string) {
+	if data == "" {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return
+	}
+	if n := len(r.pending); n > 0 && r.pending[n-1].stream == stream {
+		r.pending[n-1].data = append(r.pending[n-1].data, data...)
+	} else {
+		r.pending = append(r.pending, outputChunk{stream: stream, data: []byte(data)})
+	}
+	r.size += len(data)
+
+	if r.size >= outputFlushSize {
+		r.flush(false)
+	} else if r.timer == nil {
+		r.timer = time.AfterFunc(outputFlushInterval, func() {
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+			r.timer = nil
+			if !r.closed {
+				r.flush(false)
+			}
+		})
+	}
+}
+
+// close sends whatever is left; later output is dropped
+func (r *outputRelay) close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	r.flush(true)
+	r.closed = true
+}
+
+// flush sends the pending output. Unless final, a character split across
+// writes is held back so every message is valid UTF-8. The caller must
+// hold r.mutex.
+func (r *outputRelay) flush(final bool) {
+	var held []outputChunk
+	for _, chunk := range r.pending {
+		data := chunk.data
+		if !final {
+			var rest []byte
+			data, rest = splitIncompleteRune(data)
+			if len(rest) > 0 {
+				held = append(held, outputChunk{stream: chunk.stream, data: rest})
+			}
+		}
+		if len(data) > 0 && r.hub != nil {
+			r.hub.broadcastAll(r.job.RoomID, r.job.UserID, "execution-output", ExecutionOutput{
+				JobID:  r.job.ID,
+				Stream: chunk.stream,
+				Data:   string(data),
+			})
+		}
+	}
+
+	r.pending = held
+	r.size = 0
+	for _, chunk := range held {
+		r.size += len(chunk.data)
+	}
+}
+
+// splitIncompleteRune splits off a trailing UTF-8 sequence that is not
+// complete yet
+func splitIncompleteRune(b []byte) ([]byte, []byte) {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i], b[i:]
+			}
+			break
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,118 @@
+package sockets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+)
+
+var (
+	ErrForbidden     = errors.New("your role in the room does not allow this")
+	ErrUnknownTarget = errors.New("user is not connected to the room")
+)
+
+// Minimum role a member needs to send each message type. Guests can
+// follow the code, talk and chat; moderators can also edit, kick and
+// moderate the voice chat; admins can do everything.
+var messageRoles = map[string]int{
+	"chat":               store.RoleGuest,
+	"cursor":             store.RoleGuest,
+	"selection":          store.RoleGuest,
+	"crdt-sync":          store.RoleGuest,
+	"voice-join":         store.RoleGuest,
+	"voice-leave":        store.RoleGuest,
+	"voice-state-update": store.RoleGuest,
+	"voice-participants": store.RoleGuest,
+	"webrtc-offer":       store.RoleGuest,
+	"webrtc-answer":      store.RoleGuest,
+	"webrtc-candidate":   store.RoleGuest,
+	"webrtc-renegotiate": store.RoleGuest,
+	"media-publish":      store.RoleGuest,
+	"media-unpublish":    store.RoleGuest,
+	"execution-input":    store.RoleGuest,
+	"editor":             store.RoleModerator,
+	"crdt-update":        store.RoleModerator,
+	"kick":               store.RoleModerator,
+	"voice-mute-user":    store.RoleModerator,
+	"voice-remove-user":  store.RoleModerator,
+	"voice-lock":         store.RoleModerator,
+}
+
+// KickData is the payload of "kick" messages
+type KickData struct {
+	UserID int64 `json:"user_id"`
+}
+
+// authorize checks that c's role allows sending messages of msgType.
+// Unknown types are left to the caller.
+func (h *Hub) authorize(c *Connection, msgType string) error {
+	if role, exists := messageRoles[msgType]; exists && c.Role < role {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// removeMember deletes a user's membership of a room. A membership that
+// is gone already is fine.
+func (h *Hub) removeMember(roomID, userID int64) error {
+	if h.db == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	err := h.db.RoomStore.RemoveMember(ctx, roomID, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// HandleKickMessage disconnects a user with a lower role than the sender
+// and takes them out of the room, so they need a new invite to come back.
+// The user gets a "kicked" message before the connection is closed and
+// the rest of the room is told with "user-kicked".
+func (h *Hub) HandleKickMessage(c *Connection, msg WSMessage) {
+	var kick KickData
+	if err := decodeData(msg.Data, &kick); err != nil {
+		h.sendError(c, msg.Type, "malformed kick payload")
+		return
+	}
+
+	h.mutex.RLock()
+	target, exists := h.Rooms[c.RoomID][kick.UserID]
+	h.mutex.RUnlock()
+	if !exists {
+		h.sendError(c, msg.Type, ErrUnknownTarget.Error())
+		return
+	}
+	if target.Role >= c.Role {
+		h.sendError(c, msg.Type, ErrForbidden.Error())
+		return
+	}
+	if err := h.removeMember(c.RoomID, target.UserID); err != nil {
+		log.Printf("Removing user %d from room %d failed: %v", target.UserID, c.RoomID, err)
+		h.sendError(c, msg.Type, "could not remove the user from the room")
+		return
+	}
+
+	kicked, _ := json.Marshal(WSMessage{
+		Type:      "kicked",
+		RoomID:    c.RoomID,
+		UserID:    c.UserID,
+		Timestamp: time.Now().Unix(),
+		Data:      kick,
+	})
+	h.Unicast <- DirectMessage{
+		RoomID:  c.RoomID,
+		UserID:  target.UserID,
+		Message: kicked,
+	}
+	// Closing Send makes the writer flush the message and hang up
+	h.Unregister <- target
+	h.broadcastAll(c.RoomID, c.UserID, "user-kicked", kick)
+}
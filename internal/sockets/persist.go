@@ -0,0 +1,317 @@
+package sockets
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+)
+
+// How often changed documents are written to the database
+const SnapshotInterval = 10 * time.Second
+
+const persistTimeout = 10 * time.Second
+
+var ErrFileNotFound = errors.New("file not found")
+
+// roomDocuments holds the file tree and documents of a room while anybody
+// uses it. The first user of a room loads it without holding h.docMutex;
+// later ones wait for loaded to be closed.
+type roomDocuments struct {
+	refs  int
+	tree  map[string]store.RoomFile
+	files map[string]*roomDocument
+	// Closed once loading finished, err tells whether it worked
+	loaded chan struct{}
+	err    error
+	// Set when files change while loading, the room is then loaded again
+	stale bool
+}
+
+// loading reports whether the room is still being loaded. The caller must
+// hold h.docMutex.
+func (r *roomDocuments) loading() bool {
+	select {
+	case <-r.loaded:
+		return false
+	default:
+		return true
+	}
+}
+
+// roomDocument is a document along with the last revision written to the
+// database
+type roomDocument struct {
+	Document
+	saved int
+}
+
+// acquireRoom loads the documents of a room, creating them with the given
+// engine if needed. Every successful call must be paired with releaseRoom.
+func (h *Hub) acquireRoom(roomID int64, engine string) error {
+	h.docMutex.Lock()
+	room, exists := h.documents[roomID]
+	if exists {
+		room.refs++
+		h.docMutex.Unlock()
+		<-room.loaded
+		return room.err
+	}
+	room = &roomDocuments{refs: 1, loaded: make(chan struct{})}
+	h.documents[roomID] = room
+	h.docMutex.Unlock()
+
+	for {
+		loaded, err := h.loadRoom(roomID, engine)
+
+		h.docMutex.Lock()
+		if err == nil && room.stale {
+			// The files changed under the load, it may have missed that
+			room.stale = false
+			h.docMutex.Unlock()
+			continue
+		}
+		if err != nil {
+			room.err = err
+			delete(h.documents, roomID)
+		} else {
+			room.tree, room.files = loaded.tree, loaded.files
+		}
+		close(room.loaded)
+		h.docMutex.Unlock()
+		return err
+	}
+}
+
+// releaseRoom drops a reference taken by acquireRoom. Once nobody is using
+// the room its documents are saved and discarded; if saving fails they are
+// kept around for the next snapshot round.
+func (h *Hub) releaseRoom(roomID int64) {
+	h.docMutex.Lock()
+	room, exists := h.documents[roomID]
+	if !exists {
+		h.docMutex.Unlock()
+		return
+	}
+	room.refs--
+	if room.refs > 0 {
+		h.docMutex.Unlock()
+		return
+	}
+	snapshots := h.snapshotRoom(room)
+	h.docMutex.Unlock()
+
+	h.flush()
+	saved := h.saveSnapshots(roomID, snapshots)
+
+	h.docMutex.Lock()
+	defer h.docMutex.Unlock()
+	// Somebody may have taken the room again while it was saved
+	if saved && room.refs <= 0 && h.documents[roomID] == room {
+		delete(h.documents, roomID)
+		log.Printf("Documents of room %d closed", roomID)
+	}
+}
+
+// document returns the document of a file in a room that is held through
+// acquireRoom
+func (h *Hub) document(roomID int64, path string) (Document, error) {
+	h.docMutex.Lock()
+	defer h.docMutex.Unlock()
+
+	if room, exists := h.documents[roomID]; exists {
+		if doc, exists := room.files[path]; exists {
+			return doc.Document, nil
+		}
+	}
+	return nil, ErrFileNotFound
+}
+
+// roomState lists the file tree and the documents of a room, by path
+func (h *Hub) roomState(roomID int64) ([]store.RoomFile, []Document) {
+	h.docMutex.Lock()
+	defer h.docMutex.Unlock()
+
+	room, exists := h.documents[roomID]
+	if !exists {
+		return []store.RoomFile{}, nil
+	}
+	tree := make([]store.RoomFile, 0, len(room.tree))
+	for _, file := range room.tree {
+		tree = append(tree, file)
+	}
+	sort.Slice(tree, func(i, j int) bool { return tree[i].Path < tree[j].Path })
+
+	docs := make([]Document, 0, len(room.files))
+	for _, file := range tree {
+		if doc, exists := room.files[file.Path]; exists {
+			docs = append(docs, doc.Document)
+		}
+	}
+	return tree, docs
+}
+
+func (h *Hub) loadRoom(roomID int64, engine string) (*roomDocuments, error) {
+	room := &roomDocuments{
+		tree:  make(map[string]store.RoomFile),
+		files: make(map[string]*roomDocument),
+	}
+	if h.db == nil {
+		room.tree[store.DefaultFile] = store.RoomFile{RoomId: roomID, Path: store.DefaultFile}
+		room.files[store.DefaultFile] = &roomDocument{Document: NewDocument(roomID, store.DefaultFile, engine)}
+		return room, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	tree, err := h.db.FileStore.List(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range tree {
+		room.tree[file.Path] = file
+	}
+
+	snapshots, err := h.db.DocumentStore.ListByRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range snapshots {
+		snapshot := &snapshots[i]
+		// Edits logged after the snapshot, e.g. before a crash, are
+		// replayed so new revisions continue the log
+		tail, err := h.db.DocumentStore.GetRevisionsAfter(ctx, roomID, snapshot.Path, snapshot.Revision)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := restoreDocument(roomID, engine, snapshot, tail)
+		if err != nil {
+			return nil, err
+		}
+		room.files[snapshot.Path] = &roomDocument{Document: doc, saved: snapshot.Revision}
+	}
+
+	return room, nil
+}
+
+// restoreDocument rebuilds a document from its snapshot and the revisions
+// logged after it
+func restoreDocument(roomID int64, engine string, snapshot *store.Document,
+	tail []store.DocumentRevision) (Document, error) {
+	content, revision := snapshot.Content, snapshot.Revision
+	if len(tail) > 0 {
+		var err error
+		content, revision, err = replayTail(content, tail)
+		if err != nil {
+			log.Printf("Restoring %s of room %d: %v", snapshot.Path, roomID, err)
+		}
+	}
+
+	if engine != EngineCRDT {
+		return &OTDocument{
+			RoomID:   roomID,
+			Path:     snapshot.Path,
+			content:  []rune(content),
+			revision: revision,
+		}, nil
+	}
+
+	doc := NewCRDTDocument(roomID, snapshot.Path)
+	if snapshot.Engine == EngineCRDT && snapshot.State != nil {
+		if err := doc.restore(snapshot.State); err != nil {
+			return nil, err
+		}
+	}
+	// The logged edits carry no element IDs, the server rewrites the
+	// text they produce instead
+	if doc.text() != content {
+		doc.replaceText(content)
+	}
+	doc.base = revision - len(doc.log)
+	return doc, nil
+}
+
+// documentSnapshot is a snapshot of a document that changed since it was
+// last saved
+type documentSnapshot struct {
+	doc      *roomDocument
+	snapshot *store.Document
+}
+
+// snapshotRoom compacts the CRDT documents of a room and takes snapshots of
+// the documents that changed. The caller must hold h.docMutex and flush
+// the hub once it is released.
+func (h *Hub) snapshotRoom(room *roomDocuments) []documentSnapshot {
+	if h.db == nil {
+		return nil
+	}
+	var snapshots []documentSnapshot
+	for _, doc := range room.files {
+		if crdt, ok := doc.Document.(*CRDTDocument); ok {
+			crdt.Compact(h)
+		}
+		if snapshot := doc.Snapshot(); snapshot.Revision != doc.saved {
+			snapshots = append(snapshots, documentSnapshot{doc: doc, snapshot: snapshot})
+		}
+	}
+	return snapshots
+}
+
+// saveSnapshots writes snapshots taken by snapshotRoom and reports whether
+// all of them are saved. It must be called without h.docMutex.
+func (h *Hub) saveSnapshots(roomID int64, snapshots []documentSnapshot) bool {
+	saved := true
+	for _, s := range snapshots {
+		ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+		err := h.db.DocumentStore.Save(ctx, s.snapshot)
+		cancel()
+		if err != nil {
+			log.Printf("Saving %s of room %d failed: %v", s.snapshot.Path, roomID, err)
+			saved = false
+			continue
+		}
+
+		h.docMutex.Lock()
+		s.doc.saved = max(s.doc.saved, s.snapshot.Revision)
+		h.docMutex.Unlock()
+	}
+	return saved
+}
+
+// SaveDocuments writes every changed document and discards the rooms that
+// are no longer in use.
+func (h *Hub) SaveDocuments() {
+	h.docMutex.Lock()
+	rooms := make(map[int64]*roomDocuments, len(h.documents))
+	snapshots := make(map[int64][]documentSnapshot, len(h.documents))
+	for roomID, room := range h.documents {
+		rooms[roomID] = room
+		snapshots[roomID] = h.snapshotRoom(room)
+	}
+	h.docMutex.Unlock()
+	h.flush()
+
+	for roomID, room := range rooms {
+		saved := h.saveSnapshots(roomID, snapshots[roomID])
+
+		h.docMutex.Lock()
+		if saved && room.refs <= 0 && h.documents[roomID] == room {
+			delete(h.documents, roomID)
+		}
+		h.docMutex.Unlock()
+	}
+}
+
+// RunSnapshots periodically saves the documents that changed
+func (h *Hub) RunSnapshots(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.SaveDocuments()
+	}
+}
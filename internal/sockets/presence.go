@@ -0,0 +1,306 @@
+package sockets
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+)
+
+// How often coalesced cursor and selection updates are relayed
+const PresenceInterval = 50 * time.Millisecond
+
+// Colours handed out to room members for their cursors
+var PresenceColors = []string{
+	"#e6194b", "#3cb44b", "#4363d8", "#f58231", "#911eb4", "#42d4f4",
+	"#f032e6", "#bfef45", "#469990", "#9a6324", "#800000", "#000075",
+}
+
+// SelectionData is a selected range of a file. From is the anchor and To
+// the end the cursor is at.
+type SelectionData struct {
+	File string     `json:"file"`
+	From CursorData `json:"from"`
+	To   CursorData `json:"to"`
+}
+
+// CursorPosition is the payload of "cursor" messages
+type CursorPosition struct {
+	File string `json:"file"`
+	CursorData
+}
+
+// Presence is where a user is in the room's files
+type Presence struct {
+	UserID    int64           `json:"user_id"`
+	Color     string          `json:"color"`
+	Cursor    *CursorPosition `json:"cursor,omitempty"`
+	Selection *SelectionData  `json:"selection,omitempty"`
+}
+
+// userPresence is a user's presence along with the parts of it that
+// changed since it was last relayed
+type userPresence struct {
+	Presence
+	cursorDirty    bool
+	selectionDirty bool
+}
+
+// presenceTracker keeps the cursors and selections of every room
+type presenceTracker struct {
+	// Map of roomID -> map of userID -> presence
+	rooms map[int64]map[int64]*userPresence
+	// Map of roomID -> map of userID -> colour of the user in the room
+	colors map[int64]map[int64]string
+	mutex  sync.Mutex
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		rooms:  make(map[int64]map[int64]*userPresence),
+		colors: make(map[int64]map[int64]string),
+	}
+}
+
+// color returns the colour of a user in a room, handing out the first one
+// nobody in the room has. Once all are taken the least used one is shared.
+// The caller must hold p.mutex.
+func (p *presenceTracker) color(roomID, userID int64) string {
+	if color, exists := p.colors[roomID][userID]; exists {
+		return color
+	}
+	if p.colors[roomID] == nil {
+		p.colors[roomID] = make(map[int64]string)
+	}
+	used := make(map[string]int)
+	for _, color := range p.colors[roomID] {
+		used[color]++
+	}
+	color := PresenceColors[0]
+	for _, c := range PresenceColors {
+		if used[c] < used[color] {
+			color = c
+		}
+	}
+	p.colors[roomID][userID] = color
+	return color
+}
+
+// join returns the colour of a user joining a room
+func (p *presenceTracker) join(roomID, userID int64) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.color(roomID, userID)
+}
+
+func (p *presenceTracker) user(roomID, userID int64) *userPresence {
+	if p.rooms[roomID] == nil {
+		p.rooms[roomID] = make(map[int64]*userPresence)
+	}
+	user, exists := p.rooms[roomID][userID]
+	if !exists {
+		user = &userPresence{Presence: Presence{UserID: userID, Color: p.color(roomID, userID)}}
+		p.rooms[roomID][userID] = user
+	}
+	return user
+}
+
+// list returns the presence of every user of a room, by user id
+func (p *presenceTracker) list(roomID int64) []Presence {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	list := make([]Presence, 0, len(p.rooms[roomID]))
+	for _, user := range p.rooms[roomID] {
+		list = append(list, user.Presence)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UserID < list[j].UserID })
+	return list
+}
+
+// remove forgets a user, freeing their colour, and returns the presence
+// they had if any
+func (p *presenceTracker) remove(roomID, userID int64) (Presence, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.colors[roomID], userID)
+	if len(p.colors[roomID]) == 0 {
+		delete(p.colors, roomID)
+	}
+	room, exists := p.rooms[roomID]
+	if !exists {
+		return Presence{}, false
+	}
+	user, exists := room[userID]
+	delete(room, userID)
+	if len(room) == 0 {
+		delete(p.rooms, roomID)
+	}
+	if !exists {
+		return Presence{}, false
+	}
+	return user.Presence, true
+}
+
+// moveFile points cursors and selections inside from to the same place
+// under to. An empty to drops them, e.g. because the file was deleted.
+// Positions are replaced rather than changed, as listed presences share
+// them.
+func (p *presenceTracker) moveFile(roomID int64, from, to string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, user := range p.rooms[roomID] {
+		if user.Cursor != nil && inside(user.Cursor.File, from) {
+			if to == "" {
+				user.Cursor = nil
+			} else {
+				cursor := *user.Cursor
+				cursor.File = to + cursor.File[len(from):]
+				user.Cursor = &cursor
+			}
+		}
+		if user.Selection != nil && inside(user.Selection.File, from) {
+			if to == "" {
+				user.Selection = nil
+			} else {
+				selection := *user.Selection
+				selection.File = to + selection.File[len(from):]
+				user.Selection = &selection
+			}
+		}
+	}
+}
+
+// HandlePresenceMessage records a "cursor" or "selection" message. The
+// latest position of each user is relayed by RunPresence, so a burst of
+// moves costs the room one message per interval.
+func (h *Hub) HandlePresenceMessage(c *Connection, msg WSMessage) {
+	var file string
+	var cursor CursorPosition
+	var selection SelectionData
+
+	switch msg.Type {
+	case "cursor":
+		if err := decodeData(msg.Data, &cursor); err != nil {
+			h.sendError(c, msg.Type, "malformed cursor payload")
+			return
+		}
+		if cursor.Line < 0 || cursor.Column < 0 {
+			h.sendError(c, msg.Type, ErrBadPosition.Error())
+			return
+		}
+		file = cursor.File
+	case "selection":
+		if err := decodeData(msg.Data, &selection); err != nil {
+			h.sendError(c, msg.Type, "malformed selection payload")
+			return
+		}
+		if selection.From.Line < 0 || selection.From.Column < 0 ||
+			selection.To.Line < 0 || selection.To.Column < 0 {
+			h.sendError(c, msg.Type, ErrBadPosition.Error())
+			return
+		}
+		file = selection.File
+	}
+	if file == "" {
+		file = store.DefaultFile
+	}
+	if _, err := h.document(c.RoomID, file); err != nil {
+		h.sendError(c, msg.Type, err.Error())
+		return
+	}
+
+	h.presence.mutex.Lock()
+	defer h.presence.mutex.Unlock()
+
+	user := h.presence.user(c.RoomID, c.UserID)
+	if msg.Type == "cursor" {
+		cursor.File = file
+		user.Cursor = &cursor
+		user.cursorDirty = true
+	} else if selection.From == selection.To {
+		// An empty selection clears it
+		if user.Selection != nil {
+			user.Selection = nil
+			user.selectionDirty = true
+		}
+	} else {
+		selection.File = file
+		user.Selection = &selection
+		user.selectionDirty = true
+	}
+}
+
+// leavePresence drops a user's cursor, selection and colour and tells the
+// room
+func (h *Hub) leavePresence(roomID, userID int64) {
+	if presence, ok := h.presence.remove(roomID, userID); ok {
+		msg, _ := json.Marshal(WSMessage{
+			Type:      "presence-leave",
+			RoomID:    roomID,
+			UserID:    userID,
+			Timestamp: time.Now().Unix(),
+			Data:      Presence{UserID: userID, Color: presence.Color},
+		})
+		h.Broadcast <- BroadcastMessage{
+			RoomID:  roomID,
+			Message: msg,
+			Sender:  userID,
+		}
+	}
+}
+
+// flushPresence collects the messages for every presence that changed
+func (h *Hub) flushPresence() []BroadcastMessage {
+	h.presence.mutex.Lock()
+	defer h.presence.mutex.Unlock()
+
+	var messages []BroadcastMessage
+	now := time.Now().Unix()
+	add := func(roomID int64, msgType string, user *userPresence) {
+		msg, _ := json.Marshal(WSMessage{
+			Type:      msgType,
+			RoomID:    roomID,
+			UserID:    user.UserID,
+			Timestamp: now,
+			Data:      user.Presence,
+		})
+		messages = append(messages, BroadcastMessage{
+			RoomID:  roomID,
+			Message: msg,
+			Sender:  user.UserID,
+		})
+	}
+
+	for roomID, room := range h.presence.rooms {
+		for _, user := range room {
+			if user.cursorDirty {
+				add(roomID, "cursor", user)
+			}
+			if user.selectionDirty {
+				add(roomID, "selection", user)
+			}
+			user.cursorDirty = false
+			user.selectionDirty = false
+		}
+	}
+	return messages
+}
+
+// RunPresence relays the latest cursor and selection of users whose
+// presence changed, once per interval
+func (h *Hub) RunPresence(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, msg := range h.flushPresence() {
+			h.Broadcast <- msg
+		}
+	}
+}
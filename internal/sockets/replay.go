@@ -0,0 +1,109 @@
+package sockets
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+	"github.com/gorilla/websocket"
+)
+
+// ReplaySpeeds are the playback rates a replay can be streamed at
+var ReplaySpeeds = map[float64]bool{1: true, 2: true, 10: true}
+
+// ReplayEvent is one accepted edit of a room's recording
+type ReplayEvent struct {
+	File     string         `json:"file"`
+	Revision int            `json:"revision"`
+	UserID   int64          `json:"user_id"`
+	Time     time.Time      `json:"time"`
+	Change   CodeChangeData `json:"change"`
+}
+
+// ReplayStart is the payload of the "replay-start" message
+type ReplayStart struct {
+	Events int     `json:"events"`
+	Speed  float64 `json:"speed"`
+}
+
+// BuildReplay turns a room's revision log into editor changes expressed
+// in lines and columns, as clients received them live. Edits of the
+// room's files are interleaved in the order they were made.
+func BuildReplay(revisions []store.DocumentRevision) ([]ReplayEvent, error) {
+	events := make([]ReplayEvent, 0, len(revisions))
+	files := make(map[string][]rune)
+
+	for _, rev := range revisions {
+		text, started := files[rev.Path]
+		op := TextOperation{Position: rev.Position, Delete: rev.Delete, Insert: rev.Insert}
+		if op.Position < 0 || op.Delete < 0 || op.Position+op.Delete > len(text) {
+			// Only the first entry of a file may be made against text that
+			// was never recorded, it is picked up from the checkpoint as a
+			// whole-document change
+			if started || rev.Snapshot == nil {
+				return nil, fmt.Errorf("%w: %s at revision %d", ErrCorruptHistory, rev.Path, rev.Revision)
+			}
+			op = TextOperation{Position: 0, Delete: len(text), Insert: *rev.Snapshot}
+		}
+
+		events = append(events, ReplayEvent{
+			File:     rev.Path,
+			Revision: rev.Revision,
+			UserID:   rev.UserId,
+			Time:     rev.CreatedAt,
+			Change: CodeChangeData{
+				File:     rev.Path,
+				Revision: rev.Revision,
+				Content:  op.Insert,
+				From:     positionOf(text, op.Position),
+				To:       positionOf(text, op.Position+op.Delete),
+			},
+		})
+		files[rev.Path] = replaceRunes(text, op.Position, op.Delete, op.Insert)
+	}
+
+	return events, nil
+}
+
+// StreamReplay plays events back over conn as "editor" messages, keeping
+// their original pacing divided by speed. A positive maxGap shortens idle
+// stretches longer than it before scaling. It stops early when done is
+// closed, e.g. because the viewer went away.
+func StreamReplay(conn *websocket.Conn, roomID int64, events []ReplayEvent,
+	speed float64, maxGap time.Duration, done <-chan struct{}) error {
+	send := func(msgType string, userID, timestamp int64, data any) error {
+		msg, _ := json.Marshal(WSMessage{
+			Type:      msgType,
+			RoomID:    roomID,
+			UserID:    userID,
+			Timestamp: timestamp,
+			Data:      data,
+		})
+		return conn.WriteMessage(websocket.TextMessage, msg)
+	}
+
+	err := send("replay-start", 0, time.Now().Unix(), ReplayStart{Events: len(events), Speed: speed})
+	if err != nil {
+		return err
+	}
+
+	for i, event := range events {
+		if i > 0 {
+			gap := event.Time.Sub(events[i-1].Time)
+			if maxGap > 0 {
+				gap = min(gap, maxGap)
+			}
+			select {
+			case <-time.After(time.Duration(float64(gap) / speed)):
+			case <-done:
+				return nil
+			}
+		}
+		if err := send("editor", event.UserID, event.Time.Unix(), event.Change); err != nil {
+			return err
+		}
+	}
+
+	return send("replay-end", 0, time.Now().Unix(), nil)
+}
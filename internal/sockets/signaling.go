@@ -0,0 +1,71 @@
+package sockets
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var (
+	ErrNotInVoice       = errors.New("you are not in the voice chat")
+	ErrTargetNotInVoice = errors.New("target user is not in the voice chat")
+)
+
+// signalingTarget is what every WebRTC signaling payload has in common
+type signalingTarget struct {
+	TargetUser int64 `json:"target_user"`
+}
+
+// InVoiceChat reports whether a user is in the voice chat of a room
+func (vcm *VoiceChatManager) InVoiceChat(roomID, userID int64) bool {
+	vcm.mutex.RLock()
+	defer vcm.mutex.RUnlock()
+
+	voiceChat, exists := vcm.VoiceChats[roomID]
+	if !exists {
+		return false
+	}
+	voiceChat.mutex.RLock()
+	defer voiceChat.mutex.RUnlock()
+
+	_, exists = voiceChat.Participants[userID]
+	return exists
+}
+
+// HandleSignalingMessage delivers a "webrtc-offer", "webrtc-answer",
+// "webrtc-candidate" or "webrtc-renegotiate" to its target_user only.
+// Both peers must be in the room's voice chat and the target must still
+// be connected; otherwise the sender gets an error frame.
+func (h *Hub) HandleSignalingMessage(c *Connection, msg WSMessage, vcm *VoiceChatManager) {
+	var target signalingTarget
+	if err := decodeData(msg.Data, &target); err != nil || target.TargetUser == 0 {
+		h.sendError(c, msg.Type, "malformed signaling payload")
+		return
+	}
+	if target.TargetUser == c.UserID {
+		h.sendError(c, msg.Type, ErrUnknownTarget.Error())
+		return
+	}
+	if !vcm.InVoiceChat(c.RoomID, c.UserID) {
+		h.sendError(c, msg.Type, ErrNotInVoice.Error())
+		return
+	}
+	if !vcm.InVoiceChat(c.RoomID, target.TargetUser) {
+		h.sendError(c, msg.Type, ErrTargetNotInVoice.Error())
+		return
+	}
+
+	h.mutex.RLock()
+	_, connected := h.Rooms[c.RoomID][target.TargetUser]
+	h.mutex.RUnlock()
+	if !connected {
+		h.sendError(c, msg.Type, ErrUnknownTarget.Error())
+		return
+	}
+
+	signal, _ := json.Marshal(msg)
+	h.Unicast <- DirectMessage{
+		RoomID:  c.RoomID,
+		UserID:  target.TargetUser,
+		Message: signal,
+	}
+}
@@ -6,28 +6,46 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
 	"github.com/gorilla/websocket"
 )
 
 const pongWait = 60 * time.Second
 const pingPeriod = (pongWait * 9) / 10
 
+// Messages buffered per connection before it is considered dead
+const SendBufferSize = 256
+
 type Connection struct {
-	RoomID int64
-	UserID int64
-	Conn   *websocket.Conn
-	Send   chan []byte
+	RoomID   int64
+	UserID   int64
+	Role     int    // Role of the user in the room, see store.RoleGuest
+	Engine   string // Sync engine of the room's document
+	Language string
+	Conn     *websocket.Conn
+	Send     chan []byte
 }
 
 type Hub struct {
 	// Map of roomID -> map of userID -> Connection
 	Rooms map[int64]map[int64]*Connection
 	mutex sync.RWMutex
+	// Map of roomID -> canonical documents of the room's files
+	documents map[int64]*roomDocuments
+	docMutex  sync.Mutex
+	db        *store.Storage
+	revisions chan store.DocumentRevision
+	presence  *presenceTracker
+	// Jobs input typed in the room is for, set by NewExecutionQueue
+	executions *ExecutionQueue
+	// Messages queued under document locks, see enqueue
+	outbox outbox
 	// Channels for hub operations
 	Register   chan *Connection
 	Unregister chan *Connection
 	Broadcast  chan BroadcastMessage
 	ChatCast   chan ChatBroadcast
+	Unicast    chan DirectMessage
 }
 
 type BroadcastMessage struct {
@@ -42,6 +60,13 @@ type ChatBroadcast struct {
 	Sender  int64 // Not to send back to sender
 }
 
+// DirectMessage is delivered to a single user of a room
+type DirectMessage struct {
+	RoomID  int64
+	UserID  int64
+	Message []byte
+}
+
 type WSMessage struct {
 	RoomID    int64  `json:"room_id"`
 	UserID    int64  `json:"user_id"`
@@ -50,24 +75,144 @@ type WSMessage struct {
 	Data      any    `json:"data"`
 }
 
+// CodeChangeData replaces the text between From and To in File with
+// Content. Revision is the document revision the change was made against.
 type CodeChangeData struct {
-	Content string     `json:"content"`
-	From    CursorData `json:"from"`
-	To      CursorData `json:"to"`
+	File     string     `json:"file"`
+	Revision int        `json:"revision"`
+	Content  string     `json:"content"`
+	From     CursorData `json:"from"`
+	To       CursorData `json:"to"`
 }
 
+// CursorData is a zero based position in the document
 type CursorData struct {
 	Line   int `json:"line"`
 	Column int `json:"column"`
 }
 
+// ErrorData is the payload of "error" frames sent back to a client
+// whose message was rejected.
+type ErrorData struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// decodeData converts the generic Data of a WSMessage into v
+func decodeData(data any, v any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// broadcastAll sends a message to every connection of a room
+func (h *Hub) broadcastAll(roomID, userID int64, msgType string, data any) {
+	h.Broadcast <- roomMessage(roomID, userID, msgType, data)
+}
+
+// roomMessage is a message for every connection of a room
+func roomMessage(roomID, userID int64, msgType string, data any) BroadcastMessage {
+	msg := WSMessage{
+		Type:      msgType,
+		RoomID:    roomID,
+		UserID:    userID,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	}
+	broadcastMsg, _ := json.Marshal(msg)
+	return BroadcastMessage{
+		RoomID:  roomID,
+		Message: broadcastMsg,
+		Sender:  0, // no connection has id 0, so nobody is skipped
+	}
+}
+
+// outbox holds the messages documents queue while locked. They are sent
+// in the order they were queued once the lock is released, so clients see
+// revisions in order without the hub's channels being waited on under a
+// document lock.
+type outbox struct {
+	queue   []any // DirectMessage or BroadcastMessage
+	mutex   sync.Mutex
+	sending sync.Mutex // held while the queue is sent
+}
+
+// enqueue queues a DirectMessage or BroadcastMessage for flush
+func (h *Hub) enqueue(msg any) {
+	h.outbox.mutex.Lock()
+	defer h.outbox.mutex.Unlock()
+
+	h.outbox.queue = append(h.outbox.queue, msg)
+}
+
+// flush sends the queued messages. It must be called without holding a
+// document lock.
+func (h *Hub) flush() {
+	h.outbox.sending.Lock()
+	defer h.outbox.sending.Unlock()
+
+	for {
+		h.outbox.mutex.Lock()
+		queue := h.outbox.queue
+		h.outbox.queue = nil
+		h.outbox.mutex.Unlock()
+		if len(queue) == 0 {
+			return
+		}
+
+		for _, msg := range queue {
+			switch msg := msg.(type) {
+			case DirectMessage:
+				h.Unicast <- msg
+			case BroadcastMessage:
+				h.Broadcast <- msg
+			}
+		}
+	}
+}
+
+// sendError tells the client that a message of type msgType was rejected
+func (h *Hub) sendError(c *Connection, msgType, message string) {
+	h.Unicast <- errorMessage(c.RoomID, c.UserID, msgType, message)
+}
+
+// errorMessage tells a user of a room that their message of msgType failed
+func errorMessage(roomID, userID int64, msgType, message string) DirectMessage {
+	response := WSMessage{
+		Type:      "error",
+		RoomID:    roomID,
+		UserID:    userID,
+		Timestamp: time.Now().Unix(),
+		Data:      ErrorData{Type: msgType, Message: message},
+	}
+	responseMsg, _ := json.Marshal(response)
+	return DirectMessage{
+		RoomID:  roomID,
+		UserID:  userID,
+		Message: responseMsg,
+	}
+}
+
 func (h *Hub) ReadMessagesWithVoice(c *Connection, vcm *VoiceChatManager) {
-	defer func() {
-		// Clean up voice chat when connection closes
-		vcm.LeaveVoiceChat(c.RoomID, c.UserID)
+	if err := h.acquireRoom(c.RoomID, c.Engine); err != nil {
+		log.Printf("Loading documents of room %d failed: %v", c.RoomID, err)
+		h.sendError(c, "sync", "documents unavailable")
 		h.Unregister <- c
+		return
+	}
+	defer func() {
+		// Clean up voice chat when connection closes. After a reconnect
+		// they belong to the new connection, which replaced c already.
+		if h.unregister(c) {
+			vcm.LeaveVoiceChat(c.RoomID, c.UserID)
+			h.leavePresence(c.RoomID, c.UserID)
+		}
+		h.releaseRoom(c.RoomID)
 		c.Conn.Close()
 	}()
+	h.SendSync(c, vcm)
 
 	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.Conn.SetPongHandler(func(appData string) error {
@@ -93,6 +238,11 @@ func (h *Hub) ReadMessagesWithVoice(c *Connection, vcm *VoiceChatManager) {
 		msg.RoomID = c.RoomID
 		msg.Timestamp = time.Now().Unix()
 
+		if err := h.authorize(c, msg.Type); err != nil {
+			h.sendError(c, msg.Type, err.Error())
+			continue
+		}
+
 		// Handle voice-related messages
 		if msg.Type == "voice-join" || msg.Type == "voice-leave" ||
 			msg.Type == "voice-state-update" || msg.Type == "voice-participants" {
@@ -108,20 +258,24 @@ func (h *Hub) ReadMessagesWithVoice(c *Connection, vcm *VoiceChatManager) {
 				Message: broadcastMsg,
 				Sender:  c.UserID,
 			}
-		} else if msg.Type == "editor" {
-			h.Broadcast <- BroadcastMessage{
-				RoomID:  c.RoomID,
-				Message: broadcastMsg,
-				Sender:  c.UserID,
-			}
-		} else if msg.Type == "webrtc-offer" || msg.Type == "webrtc-answer" || msg.Type == "webrtc-candidate" {
-			h.ChatCast <- ChatBroadcast{
-				RoomID:  c.RoomID,
-				Message: broadcastMsg,
-				Sender:  c.UserID,
-			}
+		} else if msg.Type == "editor" || msg.Type == "crdt-update" || msg.Type == "crdt-sync" {
+			h.HandleDocumentMessage(c, msg)
+		} else if msg.Type == "cursor" || msg.Type == "selection" {
+			h.HandlePresenceMessage(c, msg)
+		} else if msg.Type == "webrtc-offer" || msg.Type == "webrtc-answer" ||
+			msg.Type == "webrtc-candidate" || msg.Type == "webrtc-renegotiate" {
+			h.HandleSignalingMessage(c, msg, vcm)
+		} else if msg.Type == "media-publish" || msg.Type == "media-unpublish" {
+			h.HandleMediaMessage(c, msg, vcm)
+		} else if msg.Type == "voice-mute-user" || msg.Type == "voice-remove-user" || msg.Type == "voice-lock" {
+			h.HandleVoiceModeration(c, msg, vcm)
+		} else if msg.Type == "kick" {
+			h.HandleKickMessage(c, msg)
+		} else if msg.Type == "execution-input" {
+			h.HandleExecutionInput(c, msg)
 		} else {
 			log.Printf("Invalid message type: %s", msg.Type)
+			h.sendError(c, msg.Type, "unknown message type")
 		}
 	}
 }
@@ -156,16 +310,43 @@ func (h *Hub) WriteMessages(c *Connection) {
 	}
 }
 
-func NewHub() *Hub {
+// NewHub creates a hub that persists room documents in db.
+// A nil db keeps documents in memory only.
+func NewHub(db *store.Storage) *Hub {
 	return &Hub{
 		Rooms:      make(map[int64]map[int64]*Connection),
+		documents:  make(map[int64]*roomDocuments),
+		db:         db,
+		revisions:  make(chan store.DocumentRevision, historyBufferSize),
+		presence:   newPresenceTracker(),
 		Register:   make(chan *Connection),
 		Unregister: make(chan *Connection),
 		Broadcast:  make(chan BroadcastMessage),
 		ChatCast:   make(chan ChatBroadcast),
+		Unicast:    make(chan DirectMessage),
 	}
 }
 
+// unregister removes c from its room. It reports false if the user has
+// reconnected since, in which case the new connection is left be.
+func (h *Hub) unregister(c *Connection) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	current, exists := h.Rooms[c.RoomID][c.UserID]
+	if !exists {
+		// Already dropped, e.g. as a dead connection
+		return true
+	}
+	if current != c {
+		return false
+	}
+	delete(h.Rooms[c.RoomID], c.UserID)
+	close(c.Send)
+	log.Printf("User %d left room %d", c.UserID, c.RoomID)
+	return true
+}
+
 func (h *Hub) Run() {
 	for {
 
@@ -176,21 +357,16 @@ func (h *Hub) Run() {
 			if h.Rooms[conn.RoomID] == nil {
 				h.Rooms[conn.RoomID] = make(map[int64]*Connection)
 			}
+			if old, exists := h.Rooms[conn.RoomID][conn.UserID]; exists {
+				// A user has one connection per room, drop the older one
+				close(old.Send)
+			}
 			h.Rooms[conn.RoomID][conn.UserID] = conn
 			h.mutex.Unlock()
 			log.Printf("User %d joined room %d", conn.UserID, conn.RoomID)
 
 		case conn := <-h.Unregister:
-			// Remove connection from room
-			h.mutex.Lock()
-			if room, exists := h.Rooms[conn.RoomID]; exists {
-				if _, exists := room[conn.UserID]; exists {
-					delete(room, conn.UserID)
-					close(conn.Send)
-					log.Printf("User %d left room %d", conn.UserID, conn.RoomID)
-				}
-			}
-			h.mutex.Unlock()
+			h.unregister(conn)
 
 		case msg := <-h.Broadcast:
 			h.mutex.Lock()
@@ -226,6 +402,20 @@ func (h *Hub) Run() {
 				}
 			}
 			h.mutex.Unlock()
+		case msg := <-h.Unicast:
+			h.mutex.Lock()
+			if room, exists := h.Rooms[msg.RoomID]; exists {
+				if conn, exists := room[msg.UserID]; exists {
+					select {
+					case conn.Send <- msg.Message:
+					default:
+						// Connection is dead
+						delete(room, msg.UserID)
+						close(conn.Send)
+					}
+				}
+			}
+			h.mutex.Unlock()
 		}
 	}
 }
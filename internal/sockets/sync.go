@@ -0,0 +1,108 @@
+package sockets
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+)
+
+// SyncData is the payload of the "sync" message a client receives when it
+// joins a room. Changes to a file with a revision at or below the one in
+// its DocumentState may still arrive right after it and are already part
+// of the content.
+type SyncData struct {
+	Engine    string              `json:"engine"`
+	Language  string              `json:"language"`
+	Users     []int64             `json:"users"`
+	Color     string              `json:"color"`
+	Presence  []Presence          `json:"presence"`
+	Voice     []*VoiceParticipant `json:"voice_participants"`
+	VoiceLock bool                `json:"voice_locked"`
+	Files     []store.RoomFile    `json:"files"`
+	Documents []DocumentState     `json:"documents"`
+}
+
+// DocumentState describes one file of the room
+type DocumentState struct {
+	File        string         `json:"file"`
+	Content     string         `json:"content"`
+	Revision    int            `json:"revision"`
+	Ops         []CRDTOp       `json:"ops,omitempty"`
+	StateVector map[string]int `json:"state_vector,omitempty"`
+	Compactions int            `json:"compactions,omitempty"`
+}
+
+// SendSync brings a newly registered connection up to date with the room.
+// The documents stay locked until the message is queued, so no edit falls
+// between the snapshot and the changes that follow it.
+func (h *Hub) SendSync(c *Connection, vcm *VoiceChatManager) {
+	tree, docs := h.roomState(c.RoomID)
+	data := SyncData{
+		Engine:    c.Engine,
+		Language:  c.Language,
+		Users:     h.roomUsers(c),
+		Color:     h.presence.join(c.RoomID, c.UserID),
+		Presence:  h.presence.list(c.RoomID),
+		Voice:     vcm.GetParticipants(c.RoomID),
+		VoiceLock: vcm.Locked(c.RoomID),
+		Files:     tree,
+		Documents: make([]DocumentState, 0, len(docs)),
+	}
+	if data.Voice == nil {
+		data.Voice = []*VoiceParticipant{}
+	}
+
+	// docs are sorted by path, which keeps the lock order consistent
+	for _, doc := range docs {
+		doc.lock()
+		data.Documents = append(data.Documents, doc.state())
+	}
+
+	response := WSMessage{
+		Type:      "sync",
+		RoomID:    c.RoomID,
+		UserID:    c.UserID,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	}
+	responseMsg, _ := json.Marshal(response)
+	h.enqueue(DirectMessage{
+		RoomID:  c.RoomID,
+		UserID:  c.UserID,
+		Message: responseMsg,
+	})
+	for _, doc := range docs {
+		doc.unlock()
+	}
+
+	h.flush()
+}
+
+// connections lists the connections of a room
+func (h *Hub) connections(roomID int64) []*Connection {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	connections := make([]*Connection, 0, len(h.Rooms[roomID]))
+	for _, c := range h.Rooms[roomID] {
+		connections = append(connections, c)
+	}
+	return connections
+}
+
+// roomUsers lists the users connected to c's room, including c itself
+func (h *Hub) roomUsers(c *Connection) []int64 {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	users := []int64{c.UserID}
+	for userID := range h.Rooms[c.RoomID] {
+		if userID != c.UserID {
+			users = append(users, userID)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+	return users
+}
@@ -5,6 +5,8 @@ import (
 	"log"
 	"sync"
 	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
 )
 
 // VoiceChat represents a voice chat session in a room
@@ -13,23 +15,34 @@ type VoiceChat struct {
 	Participants map[int64]*VoiceParticipant `json:"participants"`
 	Active       bool                        `json:"active"`
 	StartedAt    time.Time                   `json:"started_at"`
-	mutex        sync.RWMutex
+	// Stays of the participants who have left, recorded when the chat ends
+	attendance []store.VoiceAttendance
+	mutex      sync.RWMutex
 }
 
 // VoiceParticipant represents a user in voice chat
 type VoiceParticipant struct {
-	UserID     int64     `json:"user_id"`
-	Muted      bool      `json:"muted"`
-	Deafened   bool      `json:"deafened"`
-	JoinedAt   time.Time `json:"joined_at"`
-	Speaking   bool      `json:"speaking"`
-	AudioLevel float64   `json:"audio_level"`
+	UserID   int64 `json:"user_id"`
+	Muted    bool  `json:"muted"`
+	Deafened bool  `json:"deafened"`
+	// Muted by a moderator, the user cannot unmute themselves
+	ServerMuted bool         `json:"server_muted"`
+	JoinedAt    time.Time    `json:"joined_at"`
+	Speaking    bool         `json:"speaking"`
+	AudioLevel  float64      `json:"audio_level"`
+	Tracks      []MediaTrack `json:"tracks"`
 }
 
 // VoiceChatManager manages voice chats across rooms
 type VoiceChatManager struct {
 	VoiceChats map[int64]*VoiceChat `json:"voice_chats"`
-	mutex      sync.RWMutex
+	// Rooms whose voice chat only moderators can join
+	locked map[int64]bool
+	// Map of roomID -> users muted by a moderator. Kept apart from the
+	// participants so leaving and rejoining does not lift the mute.
+	serverMuted map[int64]map[int64]bool
+	db          *store.Storage
+	mutex       sync.RWMutex
 }
 
 // Voice chat message types
@@ -75,18 +88,27 @@ type VoiceLeaveRequest struct {
 	UserID int64 `json:"user_id"`
 }
 
-// NewVoiceChatManager creates a new voice chat manager
-func NewVoiceChatManager() *VoiceChatManager {
+// NewVoiceChatManager creates a voice chat manager that records ended
+// voice chats in db. A nil db does not record them.
+func NewVoiceChatManager(db *store.Storage) *VoiceChatManager {
 	return &VoiceChatManager{
-		VoiceChats: make(map[int64]*VoiceChat),
+		db:          db,
+		VoiceChats:  make(map[int64]*VoiceChat),
+		locked:      make(map[int64]bool),
+		serverMuted: make(map[int64]map[int64]bool),
 	}
 }
 
-// JoinVoiceChat adds a user to voice chat in a room
-func (vcm *VoiceChatManager) JoinVoiceChat(roomID, userID int64) *VoiceParticipant {
+// JoinVoiceChat adds a user with the given room role to voice chat in a
+// room. Only moderators and admins can join a locked voice chat.
+func (vcm *VoiceChatManager) JoinVoiceChat(roomID, userID int64, role int) (*VoiceParticipant, error) {
 	vcm.mutex.Lock()
 	defer vcm.mutex.Unlock()
 
+	if vcm.locked[roomID] && role < store.RoleModerator {
+		return nil, ErrVoiceLocked
+	}
+
 	// Create voice chat if it doesn't exist
 	if vcm.VoiceChats[roomID] == nil {
 		vcm.VoiceChats[roomID] = &VoiceChat{
@@ -101,20 +123,32 @@ func (vcm *VoiceChatManager) JoinVoiceChat(roomID, userID int64) *VoiceParticipa
 	voiceChat.mutex.Lock()
 	defer voiceChat.mutex.Unlock()
 
+	// Joining again ends the previous stay
+	if previous, exists := voiceChat.Participants[userID]; exists {
+		voiceChat.attendance = append(voiceChat.attendance, store.VoiceAttendance{
+			UserId:   userID,
+			JoinedAt: previous.JoinedAt,
+			LeftAt:   time.Now(),
+		})
+	}
+
 	// Add participant
+	serverMuted := vcm.serverMuted[roomID][userID]
 	participant := &VoiceParticipant{
-		UserID:     userID,
-		Muted:      false,
-		Deafened:   false,
-		JoinedAt:   time.Now(),
-		Speaking:   false,
-		AudioLevel: 0.0,
+		UserID:      userID,
+		Muted:       serverMuted,
+		Deafened:    false,
+		ServerMuted: serverMuted,
+		JoinedAt:    time.Now(),
+		Speaking:    false,
+		AudioLevel:  0.0,
+		Tracks:      []MediaTrack{},
 	}
 
 	voiceChat.Participants[userID] = participant
 	log.Printf("User %d joined voice chat in room %d", userID, roomID)
 
-	return participant
+	return participant, nil
 }
 
 // LeaveVoiceChat removes a user from voice chat
@@ -126,19 +160,31 @@ func (vcm *VoiceChatManager) LeaveVoiceChat(roomID, userID int64) {
 		voiceChat.mutex.Lock()
 		defer voiceChat.mutex.Unlock()
 
+		participant, exists := voiceChat.Participants[userID]
+		if !exists {
+			return
+		}
 		delete(voiceChat.Participants, userID)
+		voiceChat.attendance = append(voiceChat.attendance, store.VoiceAttendance{
+			UserId:   userID,
+			JoinedAt: participant.JoinedAt,
+			LeftAt:   time.Now(),
+		})
 		log.Printf("User %d left voice chat in room %d", userID, roomID)
 
 		// Clean up empty voice chats
 		if len(voiceChat.Participants) == 0 {
 			delete(vcm.VoiceChats, roomID)
 			log.Printf("Voice chat ended in room %d", roomID)
+			go vcm.record(voiceChat.session())
 		}
 	}
 }
 
-// UpdateVoiceState updates a participant's voice state
-func (vcm *VoiceChatManager) UpdateVoiceState(roomID, userID int64, update VoiceStateUpdate) {
+// UpdateVoiceState updates a participant's voice state and returns the
+// state it ended up with. A server muted participant stays muted and
+// never speaks. ok is false if the user is not in the voice chat.
+func (vcm *VoiceChatManager) UpdateVoiceState(roomID, userID int64, update VoiceStateUpdate) (state VoiceStateUpdate, ok bool) {
 	vcm.mutex.RLock()
 	defer vcm.mutex.RUnlock()
 
@@ -147,12 +193,17 @@ func (vcm *VoiceChatManager) UpdateVoiceState(roomID, userID int64, update Voice
 		defer voiceChat.mutex.Unlock()
 
 		if participant, exists := voiceChat.Participants[userID]; exists {
-			participant.Muted = update.Muted
+			participant.Muted = update.Muted || participant.ServerMuted
 			participant.Deafened = update.Deafened
-			participant.Speaking = update.Speaking
+			participant.Speaking = update.Speaking && !participant.Muted
 			participant.AudioLevel = update.AudioLevel
+			if participant.Muted {
+				participant.AudioLevel = 0
+			}
+			return participant.state(), true
 		}
 	}
+	return VoiceStateUpdate{}, false
 }
 
 // GetVoiceChat returns the voice chat for a room
@@ -192,7 +243,11 @@ func (h *Hub) HandleVoiceMessage(c *Connection, msg WSMessage, vcm *VoiceChatMan
 		if data, err := json.Marshal(msg.Data); err == nil {
 			if err := json.Unmarshal(data, &joinReq); err == nil {
 
-				participant := vcm.JoinVoiceChat(c.RoomID, c.UserID)
+				participant, err := vcm.JoinVoiceChat(c.RoomID, c.UserID, c.Role)
+				if err != nil {
+					h.sendError(c, msg.Type, err.Error())
+					return
+				}
 
 				// Broadcast to all room members that user joined voice
 				response := WSMessage{
@@ -232,11 +287,17 @@ func (h *Hub) HandleVoiceMessage(c *Connection, msg WSMessage, vcm *VoiceChatMan
 		}
 
 	case "voice-state-update":
-		var stateUpdate VoiceStateUpdate
+		var requested VoiceStateUpdate
 		if data, err := json.Marshal(msg.Data); err == nil {
-			if err := json.Unmarshal(data, &stateUpdate); err == nil {
-				stateUpdate.UserID = c.UserID
-				vcm.UpdateVoiceState(c.RoomID, c.UserID, stateUpdate)
+			if err := json.Unmarshal(data, &requested); err == nil {
+				stateUpdate, ok := vcm.UpdateVoiceState(c.RoomID, c.UserID, requested)
+				if !ok {
+					h.sendError(c, msg.Type, ErrNotInVoice.Error())
+					return
+				}
+				if stateUpdate.Muted && !requested.Muted {
+					h.sendError(c, msg.Type, ErrServerMuted.Error())
+				}
 
 				// Broadcast state update to all room members
 				response := WSMessage{
@@ -0,0 +1,75 @@
+package sockets
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/Alter-Sitanshu/CodeEditor/internal/store"
+)
+
+// VoiceChatState is a copy of the live voice chat of a room
+type VoiceChatState struct {
+	RoomID       int64              `json:"room_id"`
+	Active       bool               `json:"active"`
+	Locked       bool               `json:"locked"`
+	StartedAt    *time.Time         `json:"started_at"`
+	Participants []VoiceParticipant `json:"participants"`
+}
+
+// Snapshot returns the voice chat of a room with its participants in the
+// order they joined. A room nobody talks in has an inactive one.
+func (vcm *VoiceChatManager) Snapshot(roomID int64) VoiceChatState {
+	vcm.mutex.RLock()
+	defer vcm.mutex.RUnlock()
+
+	state := VoiceChatState{
+		RoomID:       roomID,
+		Locked:       vcm.locked[roomID],
+		Participants: []VoiceParticipant{},
+	}
+	voiceChat, exists := vcm.VoiceChats[roomID]
+	if !exists {
+		return state
+	}
+	voiceChat.mutex.RLock()
+	defer voiceChat.mutex.RUnlock()
+
+	startedAt := voiceChat.StartedAt
+	state.Active = voiceChat.Active
+	state.StartedAt = &startedAt
+	for _, participant := range voiceChat.Participants {
+		state.Participants = append(state.Participants, *participant)
+	}
+	sort.Slice(state.Participants, func(i, j int) bool {
+		return state.Participants[i].JoinedAt.Before(state.Participants[j].JoinedAt)
+	})
+	return state
+}
+
+// session is the record of a voice chat everybody has left
+func (vc *VoiceChat) session() *store.VoiceSession {
+	session := &store.VoiceSession{
+		RoomId:     vc.RoomID,
+		StartedAt:  vc.StartedAt,
+		EndedAt:    vc.StartedAt,
+		Attendance: vc.attendance,
+	}
+	for _, a := range vc.attendance {
+		if a.LeftAt.After(session.EndedAt) {
+			session.EndedAt = a.LeftAt
+		}
+	}
+	return session
+}
+
+// record saves an ended voice chat
+func (vcm *VoiceChatManager) record(session *store.VoiceSession) {
+	if vcm.db == nil {
+		return
+	}
+	if err := vcm.db.VoiceSessionStore.Create(context.Background(), session); err != nil {
+		log.Printf("Error recording voice session of room %d: %v", session.RoomId, err)
+	}
+}
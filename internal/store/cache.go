@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type CacheStore struct {
+	db *sql.DB
+}
+
+// Get returns the cached execution response stored under key since
+// after, or ErrNotFound
+func (c *CacheStore) Get(ctx context.Context, key string, after time.Time) (json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		SELECT response
+		FROM execution_cache
+		WHERE key = $1 AND created_at > $2
+	`
+	var response json.RawMessage
+	err := c.db.QueryRowContext(ctx, query, key, after).Scan(&response)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+func (c *CacheStore) Put(ctx context.Context, key string, response json.RawMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		INSERT INTO execution_cache (key, response)
+		VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET response = EXCLUDED.response, created_at = now()
+	`
+	_, err := c.db.ExecContext(ctx, query, key, []byte(response))
+	return err
+}
+
+// Prune deletes the responses cached before t
+func (c *CacheStore) Prune(ctx context.Context, before time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `DELETE FROM execution_cache WHERE created_at < $1`, before)
+	return err
+}
@@ -0,0 +1,328 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrRevisionConflict means a revision was logged twice, the document was
+// edited from a revision the log had already moved past
+var ErrRevisionConflict = errors.New("revision already logged")
+
+type DocumentStore struct {
+	db *sql.DB
+}
+
+// Document is a snapshot of one file of a room
+type Document struct {
+	RoomId    int64     `json:"room_id"`
+	Path      string    `json:"path"`
+	Content   string    `json:"content"`
+	Revision  int       `json:"revision"`
+	Engine    string    `json:"engine"`
+	State     []byte    `json:"-"` // engine specific state, e.g. the CRDT op log
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ListByRoom returns the documents of every file in the room. Files that
+// were never saved come back empty.
+func (d *DocumentStore) ListByRoom(ctx context.Context, roomID int64) ([]Document, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		SELECT f.room_id, f.path, COALESCE(d.content, ''), COALESCE(d.revision, 0),
+			COALESCE(d.sync_engine, ''), d.state, COALESCE(d.updated_at, f.created_at)
+		FROM room_files f
+		LEFT JOIN documents d ON d.room_id = f.room_id AND d.path = f.path
+		WHERE f.room_id = $1 AND f.is_dir = FALSE
+		ORDER BY f.path
+	`
+	rows, err := d.db.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []Document
+	for rows.Next() {
+		var doc Document
+		err := rows.Scan(
+			&doc.RoomId,
+			&doc.Path,
+			&doc.Content,
+			&doc.Revision,
+			&doc.Engine,
+			&doc.State,
+			&doc.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+
+	return docs, rows.Err()
+}
+
+// Save upserts the snapshot. A snapshot older than the stored one is ignored.
+func (d *DocumentStore) Save(ctx context.Context, doc *Document) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		INSERT INTO documents (room_id, path, content, revision, sync_engine, state, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, now())
+		ON CONFLICT (room_id, path) DO UPDATE
+		SET content = EXCLUDED.content,
+			revision = EXCLUDED.revision,
+			sync_engine = EXCLUDED.sync_engine,
+			state = EXCLUDED.state,
+			updated_at = EXCLUDED.updated_at
+		WHERE documents.revision <= EXCLUDED.revision
+	`
+	// Sent as text, pq would otherwise encode the bytes as bytea
+	var state sql.NullString
+	if doc.State != nil {
+		state = sql.NullString{String: string(doc.State), Valid: true}
+	}
+	_, err := d.db.ExecContext(ctx, query,
+		doc.RoomId,
+		doc.Path,
+		doc.Content,
+		doc.Revision,
+		doc.Engine,
+		state,
+	)
+
+	return err
+}
+
+// DocumentRevision is an entry of a room's append-only edit log. Every few
+// revisions the entry carries a Snapshot of the text after the edit so
+// older contents can be rebuilt without replaying the whole log.
+type DocumentRevision struct {
+	RoomId    int64     `json:"room_id"`
+	Path      string    `json:"path"`
+	Revision  int       `json:"revision"`
+	UserId    int64     `json:"user_id"`
+	Position  int       `json:"position"`
+	Delete    int       `json:"delete"`
+	Insert    string    `json:"insert"`
+	Snapshot  *string   `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AppendRevisions logs revisions. A revision that was logged already is
+// skipped without losing the rest of the batch, and reported with
+// ErrRevisionConflict once the others are stored.
+func (d *DocumentStore) AppendRevisions(ctx context.Context, revisions []DocumentRevision) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var conflicts []DocumentRevision
+	err := withTx(d.db, ctx, func(tx *sql.Tx) error {
+		// Edits of files removed in the meantime are skipped
+		query := `
+			WITH inserted AS (
+				INSERT INTO document_revisions
+				(room_id, path, revision, user_id, position, delete_count, insert_text, snapshot, created_at)
+				SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9
+				WHERE EXISTS (SELECT 1 FROM room_files WHERE room_id = $1 AND path = $2)
+				ON CONFLICT (room_id, path, revision) DO NOTHING
+				RETURNING 1
+			)
+			SELECT EXISTS (SELECT 1 FROM room_files WHERE room_id = $1 AND path = $2),
+				EXISTS (SELECT 1 FROM inserted)
+		`
+		stmt, err := tx.PrepareContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, rev := range revisions {
+			var fileExists, inserted bool
+			err := stmt.QueryRowContext(ctx,
+				rev.RoomId,
+				rev.Path,
+				rev.Revision,
+				rev.UserId,
+				rev.Position,
+				rev.Delete,
+				rev.Insert,
+				rev.Snapshot,
+				rev.CreatedAt,
+			).Scan(&fileExists, &inserted)
+			if err != nil {
+				return err
+			}
+			if fileExists && !inserted {
+				conflicts = append(conflicts, rev)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(conflicts) > 0 {
+		first := conflicts[0]
+		return fmt.Errorf("%w: %d of %d, first %s of room %d at %d", ErrRevisionConflict,
+			len(conflicts), len(revisions), first.Path, first.RoomId, first.Revision)
+	}
+	return nil
+}
+
+// GetRevisions lists the revisions of a file newest first. A zero before
+// starts from the latest revision.
+func (d *DocumentStore) GetRevisions(ctx context.Context, roomID int64, path string,
+	before, limit int) ([]DocumentRevision, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		SELECT room_id, path, revision, user_id, position, delete_count, insert_text, created_at
+		FROM document_revisions
+		WHERE room_id = $1 AND path = $2 AND ($3 = 0 OR revision < $3)
+		ORDER BY revision DESC
+		LIMIT $4
+	`
+	rows, err := d.db.QueryContext(ctx, query, roomID, path, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []DocumentRevision{}
+	for rows.Next() {
+		var rev DocumentRevision
+		err := rows.Scan(&rev.RoomId, &rev.Path, &rev.Revision, &rev.UserId,
+			&rev.Position, &rev.Delete, &rev.Insert, &rev.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, rows.Err()
+}
+
+// GetRevisionsUpTo returns the entries needed to rebuild a file at
+// revision: the closest snapshot at or before it followed by the later
+// edits, oldest first. A revision past the latest one is not found.
+func (d *DocumentStore) GetRevisionsUpTo(ctx context.Context, roomID int64, path string,
+	revision int) ([]DocumentRevision, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		SELECT room_id, path, revision, user_id, position, delete_count, insert_text, snapshot, created_at
+		FROM document_revisions
+		WHERE room_id = $1 AND path = $2 AND revision <= $3 AND revision >= COALESCE((
+			SELECT MAX(revision) FROM document_revisions
+			WHERE room_id = $1 AND path = $2 AND revision <= $3 AND snapshot IS NOT NULL
+		), 0) AND $3 <= (
+			SELECT MAX(revision) FROM document_revisions WHERE room_id = $1 AND path = $2
+		)
+		ORDER BY revision
+	`
+	rows, err := d.db.QueryContext(ctx, query, roomID, path, revision)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []DocumentRevision
+	for rows.Next() {
+		var rev DocumentRevision
+		err := rows.Scan(&rev.RoomId, &rev.Path, &rev.Revision, &rev.UserId,
+			&rev.Position, &rev.Delete, &rev.Insert, &rev.Snapshot, &rev.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(revisions) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return revisions, nil
+}
+
+// GetRevisionsAfter returns the entries of a file logged after revision,
+// oldest first
+func (d *DocumentStore) GetRevisionsAfter(ctx context.Context, roomID int64, path string,
+	revision int) ([]DocumentRevision, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		SELECT room_id, path, revision, user_id, position, delete_count, insert_text, snapshot, created_at
+		FROM document_revisions
+		WHERE room_id = $1 AND path = $2 AND revision > $3
+		ORDER BY revision
+	`
+	rows, err := d.db.QueryContext(ctx, query, roomID, path, revision)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []DocumentRevision
+	for rows.Next() {
+		var rev DocumentRevision
+		err := rows.Scan(&rev.RoomId, &rev.Path, &rev.Revision, &rev.UserId,
+			&rev.Position, &rev.Delete, &rev.Insert, &rev.Snapshot, &rev.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, rows.Err()
+}
+
+// GetRevisionLog returns the revision log of every file in a room,
+// oldest first
+func (d *DocumentStore) GetRevisionLog(ctx context.Context, roomID int64) ([]DocumentRevision, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		SELECT room_id, path, revision, user_id, position, delete_count, insert_text, snapshot, created_at
+		FROM document_revisions
+		WHERE room_id = $1
+		ORDER BY created_at, path, revision
+	`
+	rows, err := d.db.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []DocumentRevision{}
+	for rows.Next() {
+		var rev DocumentRevision
+		err := rows.Scan(&rev.RoomId, &rev.Path, &rev.Revision, &rev.UserId,
+			&rev.Position, &rev.Delete, &rev.Insert, &rev.Snapshot, &rev.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, rows.Err()
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type ExecutionStore struct {
+	db *sql.DB
+}
+
+// Execution is a finished run of code in a room
+type Execution struct {
+	Id           int64     `json:"id"`
+	JobId        string    `json:"job_id"`
+	RoomId       int64     `json:"room_id"`
+	UserId       int64     `json:"user_id"`
+	Language     string    `json:"language"`
+	CodeHash     string    `json:"code_hash"` // hex sha256 of the code
+	Stdin        string    `json:"stdin"`
+	Stdout       string    `json:"stdout"`
+	Stderr       string    `json:"stderr"`
+	Status       string    `json:"status"`        // of the job, e.g. finished or cancelled
+	ResultStatus *string   `json:"result_status"` // of the run if it finished, e.g. timeout
+	ExitCode     *int      `json:"exit_code"`
+	RuntimeMs    *int      `json:"runtime_ms"`
+	MemoryKb     *int      `json:"memory_kb"`
+	CreatedAt    time.Time `json:"created_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+}
+
+func (e *ExecutionStore) Create(ctx context.Context, exec *Execution) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		INSERT INTO executions (job_id, room_id, user_id, language, code_hash, stdin,
+			stdout, stderr, status, result_status, exit_code, runtime_ms, memory_kb, created_at, finished_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id
+	`
+	return e.db.QueryRowContext(ctx, query,
+		exec.JobId,
+		exec.RoomId,
+		exec.UserId,
+		exec.Language,
+		exec.CodeHash,
+		exec.Stdin,
+		exec.Stdout,
+		exec.Stderr,
+		exec.Status,
+		exec.ResultStatus,
+		exec.ExitCode,
+		exec.RuntimeMs,
+		exec.MemoryKb,
+		exec.CreatedAt,
+		exec.FinishedAt,
+	).Scan(&exec.Id)
+}
+
+// ListByRoom lists the executions of a room newest first, only those of
+// userID unless it is zero. A zero before starts from the latest one.
+func (e *ExecutionStore) ListByRoom(ctx context.Context, roomID, userID, before int64,
+	limit int) ([]Execution, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		SELECT id, job_id, room_id, COALESCE(user_id, 0), language, code_hash, stdin,
+			stdout, stderr, status, result_status, exit_code, runtime_ms, memory_kb, created_at, finished_at
+		FROM executions
+		WHERE room_id = $1 AND ($2::bigint = 0 OR user_id = $2) AND ($3::bigint = 0 OR id < $3)
+		ORDER BY id DESC
+		LIMIT $4
+	`
+	rows, err := e.db.QueryContext(ctx, query, roomID, userID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	executions := []Execution{}
+	for rows.Next() {
+		var exec Execution
+		err := rows.Scan(
+			&exec.Id,
+			&exec.JobId,
+			&exec.RoomId,
+			&exec.UserId,
+			&exec.Language,
+			&exec.CodeHash,
+			&exec.Stdin,
+			&exec.Stdout,
+			&exec.Stderr,
+			&exec.Status,
+			&exec.ResultStatus,
+			&exec.ExitCode,
+			&exec.RuntimeMs,
+			&exec.MemoryKb,
+			&exec.CreatedAt,
+			&exec.FinishedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		executions = append(executions, exec)
+	}
+
+	return executions, rows.Err()
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// File every room starts with
+const DefaultFile = "main"
+
+const maxPathLength = 255
+
+var (
+	ErrFileExists    = errors.New("file already exists")
+	ErrNotADirectory = errors.New("parent is not a directory")
+	ErrInvalidPath   = errors.New("path not valid")
+)
+
+var pathSegment = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+type FileStore struct {
+	db *sql.DB
+}
+
+// RoomFile is an entry of a room's file tree. Paths are relative and use
+// "/" as separator.
+type RoomFile struct {
+	RoomId    int64     `json:"room_id"`
+	Path      string    `json:"path"`
+	IsDir     bool      `json:"is_dir"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ValidPath reports whether path can name a file in a room
+func ValidPath(path string) bool {
+	if path == "" || len(path) > maxPathLength {
+		return false
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "." || segment == ".." || !pathSegment.MatchString(segment) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *FileStore) List(ctx context.Context, roomID int64) ([]RoomFile, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		SELECT room_id, path, is_dir, created_at
+		FROM room_files
+		WHERE room_id = $1
+		ORDER BY path
+	`
+	rows, err := f.db.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []RoomFile{}
+	for rows.Next() {
+		var file RoomFile
+		if err := rows.Scan(&file.RoomId, &file.Path, &file.IsDir, &file.CreatedAt); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	return files, rows.Err()
+}
+
+// Create adds a file or folder, creating missing parent folders
+func (f *FileStore) Create(ctx context.Context, file *RoomFile) error {
+	if !ValidPath(file.Path) {
+		return ErrInvalidPath
+	}
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(f.db, ctx, func(tx *sql.Tx) error {
+		if err := ensureParents(ctx, tx, file.RoomId, file.Path); err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO room_files (room_id, path, is_dir)
+			VALUES ($1, $2, $3) RETURNING created_at
+		`
+		err := tx.QueryRowContext(ctx, query, file.RoomId, file.Path, file.IsDir).Scan(
+			&file.CreatedAt,
+		)
+		if err != nil {
+			return fileError(err)
+		}
+
+		return nil
+	})
+}
+
+// Move renames a file or folder. Moving a folder moves everything in it.
+func (f *FileStore) Move(ctx context.Context, roomID int64, from, to string) error {
+	if !ValidPath(from) || !ValidPath(to) || to == from || strings.HasPrefix(to, from+"/") {
+		return ErrInvalidPath
+	}
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(f.db, ctx, func(tx *sql.Tx) error {
+		if err := ensureParents(ctx, tx, roomID, to); err != nil {
+			return err
+		}
+
+		// Documents and revisions follow through ON UPDATE CASCADE
+		query := `
+			UPDATE room_files
+			SET path = $3 || substr(path, length($2) + 1)
+			WHERE room_id = $1 AND (path = $2 OR starts_with(path, $2 || '/'))
+		`
+		res, err := tx.ExecContext(ctx, query, roomID, from, to)
+		if err != nil {
+			return fileError(err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+
+		return nil
+	})
+}
+
+// Delete removes a file or folder along with everything in it
+func (f *FileStore) Delete(ctx context.Context, roomID int64, path string) error {
+	if !ValidPath(path) {
+		return ErrInvalidPath
+	}
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(f.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			DELETE FROM room_files
+			WHERE room_id = $1 AND (path = $2 OR starts_with(path, $2 || '/'))
+		`
+		res, err := tx.ExecContext(ctx, query, roomID, path)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+
+		return nil
+	})
+}
+
+// ensureParents creates the folders leading to path
+func ensureParents(ctx context.Context, tx *sql.Tx, roomID int64, path string) error {
+	query := `
+		INSERT INTO room_files (room_id, path, is_dir)
+		VALUES ($1, $2, TRUE)
+		ON CONFLICT (room_id, path) DO UPDATE SET is_dir = room_files.is_dir
+		RETURNING is_dir
+	`
+	segments := strings.Split(path, "/")
+	for i := 1; i < len(segments); i++ {
+		var isDir bool
+		parent := strings.Join(segments[:i], "/")
+		if err := tx.QueryRowContext(ctx, query, roomID, parent).Scan(&isDir); err != nil {
+			return err
+		}
+		if !isDir {
+			return ErrNotADirectory
+		}
+	}
+	return nil
+}
+
+// Postgres error code of unique constraint violations
+const uniqueViolation = "23505"
+
+func fileError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return ErrFileExists
+	}
+	return err
+}
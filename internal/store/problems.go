@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type ProblemStore struct {
+	db *sql.DB
+}
+
+// Problem is a task a room can be judged against
+type Problem struct {
+	Id        int64         `json:"id"`
+	Title     string        `json:"title"`
+	Statement string        `json:"statement"`
+	AuthorId  int64         `json:"author_id"`
+	CreatedAt time.Time     `json:"created_at"`
+	Cases     []ProblemCase `json:"cases"`
+}
+
+// ProblemCase is an input and the output expected for it. Hidden cases
+// are only shown to the problem's author.
+type ProblemCase struct {
+	Input          string `json:"input"`
+	ExpectedOutput string `json:"expected_output"`
+	Hidden         bool   `json:"hidden"`
+	TimeLimitMs    int    `json:"time_limit_ms"`
+	MemoryLimitKb  int    `json:"memory_limit_kb"`
+}
+
+// Samples returns the cases that are not hidden
+func (p *Problem) Samples() []ProblemCase {
+	samples := []ProblemCase{}
+	for _, c := range p.Cases {
+		if !c.Hidden {
+			samples = append(samples, c)
+		}
+	}
+	return samples
+}
+
+func (p *ProblemStore) Create(ctx context.Context, problem *Problem) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(p.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO problems (title, statement, author)
+			VALUES ($1, $2, $3) RETURNING id, created_at
+		`
+		err := tx.QueryRowContext(ctx, query,
+			problem.Title,
+			problem.Statement,
+			problem.AuthorId,
+		).Scan(
+			&problem.Id,
+			&problem.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO problem_cases (problem_id, position, input, expected_output,
+				hidden, time_limit_ms, memory_limit_kb)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`
+		for i, c := range problem.Cases {
+			_, err := tx.ExecContext(ctx, query,
+				problem.Id,
+				i,
+				c.Input,
+				c.ExpectedOutput,
+				c.Hidden,
+				c.TimeLimitMs,
+				c.MemoryLimitKb,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetById returns a problem with all of its cases in order
+func (p *ProblemStore) GetById(ctx context.Context, problemID int64) (*Problem, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		SELECT id, title, statement, COALESCE(author, 0), created_at
+		FROM problems
+		WHERE id = $1
+	`
+	problem := &Problem{}
+	err := p.db.QueryRowContext(ctx, query, problemID).Scan(
+		&problem.Id,
+		&problem.Title,
+		&problem.Statement,
+		&problem.AuthorId,
+		&problem.CreatedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+		SELECT input, expected_output, hidden, time_limit_ms, memory_limit_kb
+		FROM problem_cases
+		WHERE problem_id = $1
+		ORDER BY position
+	`
+	rows, err := p.db.QueryContext(ctx, query, problemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	problem.Cases = []ProblemCase{}
+	for rows.Next() {
+		var c ProblemCase
+		err := rows.Scan(&c.Input, &c.ExpectedOutput, &c.Hidden, &c.TimeLimitMs, &c.MemoryLimitKb)
+		if err != nil {
+			return nil, err
+		}
+		problem.Cases = append(problem.Cases, c)
+	}
+
+	return problem, rows.Err()
+}
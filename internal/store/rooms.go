@@ -8,6 +8,14 @@ import (
 	"time"
 )
 
+// Roles a member can have in a room, see the roles table. A higher role
+// can do everything a lower one can.
+const (
+	RoleGuest     = 1
+	RoleModerator = 2
+	RoleAdmin     = 3
+)
+
 type RoomStore struct {
 	db *sql.DB
 }
@@ -17,6 +25,8 @@ type Room struct {
 	Name      string    `json:"name"`
 	Author    *User     `json:"author"`
 	Language  string    `json:"lang"`
+	Engine    string    `json:"engine"`
+	ProblemId *int64    `json:"problem_id"`
 	CreatedAt time.Time `json:"created_at"`
 	Members   []Member  `json:"members"`
 }
@@ -40,13 +50,14 @@ func (r *RoomStore) Create(ctx context.Context, room *Room) error {
 
 	return withTx(r.db, ctx, func(tx *sql.Tx) error {
 		query := `
-			INSERT INTO rooms (name, author, language)
-			VALUES ($1, $2, $3) RETURNING id, created_at
+			INSERT INTO rooms (name, author, language, sync_engine)
+			VALUES ($1, $2, $3, $4) RETURNING id, created_at
 		`
 		err := tx.QueryRowContext(ctx, query,
 			room.Name,
 			room.Author.Id,
 			room.Language,
+			room.Engine,
 		).Scan(
 			&room.Id,
 			&room.CreatedAt,
@@ -65,6 +76,15 @@ func (r *RoomStore) Create(ctx context.Context, room *Room) error {
 			return err
 		}
 
+		query = `
+			INSERT INTO room_files (room_id, path)
+			VALUES ($1, $2)
+		`
+		_, err = tx.ExecContext(ctx, query, room.Id, DefaultFile)
+		if err != nil {
+			return err
+		}
+
 		return nil
 	})
 
@@ -72,7 +92,7 @@ func (r *RoomStore) Create(ctx context.Context, room *Room) error {
 
 func (r *RoomStore) GetUserRooms(ctx context.Context, user *User) ([]Room, error) {
 	query := `
-		SELECT r.id, r.name, r.language, r.author, u.fname, u.lname
+		SELECT r.id, r.name, r.language, r.sync_engine, r.author, u.fname, u.lname
 		FROM room_users ru
 		JOIN rooms r ON r.id = ru.room_id
 		JOIN users u ON u.id = r.author
@@ -88,7 +108,7 @@ func (r *RoomStore) GetUserRooms(ctx context.Context, user *User) ([]Room, error
 	for rows.Next() {
 		var ro Room
 		ro.Author = &User{}
-		err := rows.Scan(&ro.Id, &ro.Name, &ro.Language,
+		err := rows.Scan(&ro.Id, &ro.Name, &ro.Language, &ro.Engine,
 			&ro.Author.Id, &ro.Author.FirstName, &ro.Author.LastName,
 		)
 		if err != nil {
@@ -103,7 +123,8 @@ func (r *RoomStore) GetUserRooms(ctx context.Context, user *User) ([]Room, error
 
 func (r *RoomStore) GetRoomById(ctx context.Context, roomID int64) (*Room, error) {
 	query := `
-		SELECT u.fname, u.lname, r.name, r.language
+		SELECT r.id, r.author, u.fname, u.lname, r.name, r.language, r.sync_engine,
+			r.problem_id, r.created_at
 		FROM rooms r
 		JOIN users u ON u.id = r.author
 		WHERE r.id = $1
@@ -111,13 +132,23 @@ func (r *RoomStore) GetRoomById(ctx context.Context, roomID int64) (*Room, error
 	roomresp := Room{}
 	roomresp.Author = &User{}
 	err := r.db.QueryRowContext(ctx, query, roomID).Scan(
+		&roomresp.Id,
+		&roomresp.Author.Id,
 		&roomresp.Author.FirstName,
 		&roomresp.Author.LastName,
 		&roomresp.Name,
 		&roomresp.Language,
+		&roomresp.Engine,
+		&roomresp.ProblemId,
+		&roomresp.CreatedAt,
 	)
 	if err != nil {
-		return nil, err
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
 	}
 
 	members, err := r.getMembers(ctx, roomID)
@@ -162,6 +193,31 @@ func (r *RoomStore) getMembers(ctx context.Context, roomID int64) ([]Member, err
 
 }
 
+// GetMemberRole returns the role of a user in a room, or ErrNotFound if
+// the user is not a member
+func (r *RoomStore) GetMemberRole(ctx context.Context, roomID, userID int64) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		SELECT roleid
+		FROM room_users
+		WHERE room_id = $1 AND user_id = $2
+	`
+	var role int
+	err := r.db.QueryRowContext(ctx, query, roomID, userID).Scan(&role)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return 0, ErrNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return role, nil
+}
+
 func (r *RoomStore) AddMember(ctx context.Context, tx *sql.Tx, roomID, userID int64, roleid int64) error {
 	query := `
 		INSERT INTO room_users (room_id, user_id, roleid)
@@ -181,6 +237,23 @@ func (r *RoomStore) AddMember(ctx context.Context, tx *sql.Tx, roomID, userID in
 	return nil
 }
 
+// RemoveMember takes a user out of a room, or returns ErrNotFound if the
+// user is not a member
+func (r *RoomStore) RemoveMember(ctx context.Context, roomID, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `DELETE FROM room_users WHERE room_id = $1 AND user_id = $2`
+	res, err := r.db.ExecContext(ctx, query, roomID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 func (r *RoomStore) CreateNewJoinToken(ctx context.Context,
 	exp time.Duration, roomid, userid, roleid int64, token string) error {
 	return withTx(r.db, ctx, func(tx *sql.Tx) error {
@@ -255,3 +328,20 @@ func (r *RoomStore) AcceptJoinRequest(ctx context.Context, token string, expiry
 		return nil
 	})
 }
+
+// SetProblem sets the problem submissions in a room are judged against,
+// nil to clear it
+func (r *RoomStore) SetProblem(ctx context.Context, roomID int64, problemID *int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `UPDATE rooms SET problem_id = $1 WHERE id = $2`
+	res, err := r.db.ExecContext(ctx, query, problemID, roomID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
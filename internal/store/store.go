@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"time"
 
@@ -30,11 +31,52 @@ type Storage struct {
 		Create(context.Context, *Room) error
 		GetUserRooms(context.Context, *User) ([]Room, error)
 		GetRoomById(context.Context, int64) (*Room, error)
+		GetMemberRole(context.Context, int64, int64) (int, error)
+		SetProblem(context.Context, int64, *int64) error
 		AddMember(context.Context, *sql.Tx, int64, int64, int64) error
+		RemoveMember(context.Context, int64, int64) error
 		authorise(context.Context, *sql.Tx, string, time.Time) (*RoomUser, error)
 		AcceptJoinRequest(context.Context, string, time.Time) error
 		CreateNewJoinToken(context.Context, time.Duration, int64, int64, int64, string) error
 	}
+	DocumentStore interface {
+		ListByRoom(context.Context, int64) ([]Document, error)
+		Save(context.Context, *Document) error
+		AppendRevisions(context.Context, []DocumentRevision) error
+		GetRevisions(context.Context, int64, string, int, int) ([]DocumentRevision, error)
+		GetRevisionsUpTo(context.Context, int64, string, int) ([]DocumentRevision, error)
+		GetRevisionsAfter(context.Context, int64, string, int) ([]DocumentRevision, error)
+		GetRevisionLog(context.Context, int64) ([]DocumentRevision, error)
+	}
+	ExecutionStore interface {
+		Create(context.Context, *Execution) error
+		ListByRoom(context.Context, int64, int64, int64, int) ([]Execution, error)
+	}
+	ProblemStore interface {
+		Create(context.Context, *Problem) error
+		GetById(context.Context, int64) (*Problem, error)
+	}
+	UsageStore interface {
+		Consume(context.Context, int64, int64, int, int, int) (UsageCharge, error)
+		Refund(context.Context, UsageCharge) error
+		List(context.Context, time.Time, string) ([]Usage, error)
+		Reset(context.Context, string, int64, time.Time) error
+	}
+	CacheStore interface {
+		Get(context.Context, string, time.Time) (json.RawMessage, error)
+		Put(context.Context, string, json.RawMessage) error
+		Prune(context.Context, time.Time) error
+	}
+	VoiceSessionStore interface {
+		Create(context.Context, *VoiceSession) error
+		ListByRoom(context.Context, int64, int64, int) ([]VoiceSession, error)
+	}
+	FileStore interface {
+		List(context.Context, int64) ([]RoomFile, error)
+		Create(context.Context, *RoomFile) error
+		Move(context.Context, int64, string, string) error
+		Delete(context.Context, int64, string) error
+	}
 }
 
 func Mount(addr string, MaxConns, MaxIdleConns, MaxIdleTime int) (*sql.DB, error) {
@@ -63,6 +105,27 @@ func NewPostgresStore(db *sql.DB) Storage {
 		RoomStore: &RoomStore{
 			db: db,
 		},
+		DocumentStore: &DocumentStore{
+			db: db,
+		},
+		FileStore: &FileStore{
+			db: db,
+		},
+		ExecutionStore: &ExecutionStore{
+			db: db,
+		},
+		ProblemStore: &ProblemStore{
+			db: db,
+		},
+		UsageStore: &UsageStore{
+			db: db,
+		},
+		CacheStore: &CacheStore{
+			db: db,
+		},
+		VoiceSessionStore: &VoiceSessionStore{
+			db: db,
+		},
 	}
 }
 
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Scopes daily execution usage is counted in
+const (
+	UsageUser = "user"
+	UsageRoom = "room"
+)
+
+var (
+	ErrUserQuotaExceeded = errors.New("daily execution quota of the user exceeded")
+	ErrRoomQuotaExceeded = errors.New("daily execution quota of the room exceeded")
+)
+
+type UsageStore struct {
+	db *sql.DB
+}
+
+// Usage is how many executions a user or room ran on a day (UTC)
+type Usage struct {
+	Scope     string    `json:"scope"`
+	SubjectId int64     `json:"subject_id"`
+	Day       time.Time `json:"day"`
+	Count     int       `json:"count"`
+}
+
+// UsageDay returns the day usage at t counts towards
+func UsageDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// UsageCharge is a number of executions counted towards the quotas of a
+// user and room by Consume, kept to give them back with Refund
+type UsageCharge struct {
+	UserId int64
+	RoomId int64
+	Day    time.Time
+	Count  int
+}
+
+// Consume counts n executions of userID in roomID today, unless that
+// takes the user past userLimit or the room past roomLimit. A zero limit
+// is no limit.
+func (u *UsageStore) Consume(ctx context.Context, userID, roomID int64, n, userLimit,
+	roomLimit int) (UsageCharge, error) {
+	if userLimit > 0 && n > userLimit {
+		return UsageCharge{}, ErrUserQuotaExceeded
+	}
+	if roomLimit > 0 && n > roomLimit {
+		return UsageCharge{}, ErrRoomQuotaExceeded
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	day := UsageDay(time.Now())
+	err := withTx(u.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO execution_usage (scope, subject_id, day, count)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (scope, subject_id, day) DO UPDATE
+			SET count = execution_usage.count + EXCLUDED.count
+			WHERE $5::int = 0 OR execution_usage.count + EXCLUDED.count <= $5
+			RETURNING count
+		`
+		var count int
+		err := tx.QueryRowContext(ctx, query, UsageUser, userID, day, n, userLimit).Scan(&count)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrUserQuotaExceeded
+			default:
+				return err
+			}
+		}
+
+		err = tx.QueryRowContext(ctx, query, UsageRoom, roomID, day, n, roomLimit).Scan(&count)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrRoomQuotaExceeded
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return UsageCharge{}, err
+	}
+
+	return UsageCharge{UserId: userID, RoomId: roomID, Day: day, Count: n}, nil
+}
+
+// Refund takes executions that never ran off the day they were counted
+// towards
+func (u *UsageStore) Refund(ctx context.Context, charge UsageCharge) error {
+	if charge.Count <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(u.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE execution_usage
+			SET count = GREATEST(count - $4, 0)
+			WHERE scope = $1 AND subject_id = $2 AND day = $3
+		`
+		if _, err := tx.ExecContext(ctx, query, UsageUser, charge.UserId, charge.Day, charge.Count); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, query, UsageRoom, charge.RoomId, charge.Day, charge.Count)
+		return err
+	})
+}
+
+// List returns the usage of a day, of one scope unless scope is empty,
+// highest first
+func (u *UsageStore) List(ctx context.Context, day time.Time, scope string) ([]Usage, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		SELECT scope, subject_id, day, count
+		FROM execution_usage
+		WHERE day = $1 AND ($2 = '' OR scope = $2)
+		ORDER BY count DESC, scope, subject_id
+	`
+	rows, err := u.db.QueryContext(ctx, query, UsageDay(day), scope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []Usage{}
+	for rows.Next() {
+		var entry Usage
+		if err := rows.Scan(&entry.Scope, &entry.SubjectId, &entry.Day, &entry.Count); err != nil {
+			return nil, err
+		}
+		usage = append(usage, entry)
+	}
+
+	return usage, rows.Err()
+}
+
+// Reset clears the usage of a user or room on a day
+func (u *UsageStore) Reset(ctx context.Context, scope string, subjectID int64, day time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		DELETE FROM execution_usage
+		WHERE scope = $1 AND subject_id = $2 AND day = $3
+	`
+	_, err := u.db.ExecContext(ctx, query, scope, subjectID, UsageDay(day))
+	return err
+}
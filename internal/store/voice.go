@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type VoiceSessionStore struct {
+	db *sql.DB
+}
+
+// VoiceSession is a voice chat of a room, from its first participant
+// joining to its last one leaving
+type VoiceSession struct {
+	Id              int64             `json:"id"`
+	RoomId          int64             `json:"room_id"`
+	StartedAt       time.Time         `json:"started_at"`
+	EndedAt         time.Time         `json:"ended_at"`
+	DurationSeconds int64             `json:"duration_seconds"`
+	Attendance      []VoiceAttendance `json:"attendance"`
+}
+
+// VoiceAttendance is one stay of a user in a voice session. A user who
+// leaves and rejoins has one for each stay.
+type VoiceAttendance struct {
+	UserId          int64     `json:"user_id"`
+	JoinedAt        time.Time `json:"joined_at"`
+	LeftAt          time.Time `json:"left_at"`
+	DurationSeconds int64     `json:"duration_seconds"`
+}
+
+func (v *VoiceSessionStore) Create(ctx context.Context, session *VoiceSession) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	return withTx(v.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO voice_sessions (room_id, started_at, ended_at)
+			VALUES ($1, $2, $3) RETURNING id
+		`
+		err := tx.QueryRowContext(ctx, query,
+			session.RoomId,
+			session.StartedAt,
+			session.EndedAt,
+		).Scan(&session.Id)
+		if err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO voice_session_attendance (session_id, user_id, joined_at, left_at)
+			VALUES ($1, $2, $3, $4)
+		`
+		for _, a := range session.Attendance {
+			_, err := tx.ExecContext(ctx, query, session.Id, a.UserId, a.JoinedAt, a.LeftAt)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListByRoom lists the voice sessions of a room newest first, each with
+// its attendance in the order users joined. A zero before starts from the
+// latest one.
+func (v *VoiceSessionStore) ListByRoom(ctx context.Context, roomID, before int64,
+	limit int) ([]VoiceSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	query := `
+		SELECT s.id, s.room_id, s.started_at, s.ended_at,
+			COALESCE(a.user_id, 0), a.joined_at, a.left_at
+		FROM (
+			SELECT id, room_id, started_at, ended_at
+			FROM voice_sessions
+			WHERE room_id = $1 AND ($2::bigint = 0 OR id < $2)
+			ORDER BY id DESC
+			LIMIT $3
+		) s
+		JOIN voice_session_attendance a ON a.session_id = s.id
+		ORDER BY s.id DESC, a.joined_at, a.id
+	`
+	rows, err := v.db.QueryContext(ctx, query, roomID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []VoiceSession{}
+	for rows.Next() {
+		var session VoiceSession
+		var a VoiceAttendance
+		err := rows.Scan(
+			&session.Id,
+			&session.RoomId,
+			&session.StartedAt,
+			&session.EndedAt,
+			&a.UserId,
+			&a.JoinedAt,
+			&a.LeftAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		a.DurationSeconds = int64(a.LeftAt.Sub(a.JoinedAt).Seconds())
+
+		// Rows of a session are next to each other
+		if n := len(sessions); n == 0 || sessions[n-1].Id != session.Id {
+			session.DurationSeconds = int64(session.EndedAt.Sub(session.StartedAt).Seconds())
+			session.Attendance = []VoiceAttendance{}
+			sessions = append(sessions, session)
+		}
+		last := &sessions[len(sessions)-1]
+		last.Attendance = append(last.Attendance, a)
+	}
+
+	return sessions, rows.Err()
+}